@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+// cronCmd represents the cron command
+var cronCmd = &cobra.Command{
+	Use:   "cron",
+	Short: "Manage cron tasks.",
+	Long: `cube cron command.
+
+Cron tasks start a new task every time their cron schedule fires. The manager
+keeps them in its store, so schedules survive manager restarts.`,
+}
+
+var cronCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a cron task.",
+	Long: `cube cron create command.
+
+The create command registers a new cron task with the manager from a cron
+task specification file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		fullFilePath, err := filepath.Abs(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !fileExists(fullFilePath) {
+			log.Fatalf("File %s does not exist", filename)
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		url := fmt.Sprintf("http://%s/crons", manager)
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error creating cron task (%d): %s", resp.StatusCode, body)
+		}
+
+		var cron task.CronTask
+		json.Unmarshal(body, &cron)
+		log.Printf("Created cron task %s (%v)", cron.Name, cron.ID)
+	},
+}
+
+var cronListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cron tasks.",
+	Long: `cube cron list command.
+
+The list command shows the cron tasks known to the manager.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		url := fmt.Sprintf("http://%s/crons", manager)
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var crons []*task.CronTask
+		err = json.NewDecoder(resp.Body).Decode(&crons)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tSCHEDULE\tTIMEZONE\tPOLICY\tACTIVE\tLAST SCHEDULE\t")
+		for _, c := range crons {
+			last := "never"
+			if !c.LastScheduleTime.IsZero() {
+				last = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(c.LastScheduleTime)))
+			}
+			tz := c.TimeZone
+			if tz == "" {
+				tz = "UTC"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t\n", c.ID, c.Name, c.Schedule, tz, c.ConcurrencyPolicy, len(c.Active), last)
+		}
+		w.Flush()
+	},
+}
+
+var cronDeleteCmd = &cobra.Command{
+	Use:   "delete <cron-id>",
+	Short: "Delete a cron task.",
+	Long: `cube cron delete command.
+
+The delete command removes a cron task so that it isn't run again. Runs that
+are already active are not stopped.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		url := fmt.Sprintf("http://%s/crons/%s", manager, args[0])
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error making request %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error deleting cron task %v (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		log.Printf("Cron task %v has been deleted", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cronCmd)
+	cronCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+
+	cronCmd.AddCommand(cronCreateCmd)
+	cronCreateCmd.Flags().StringP("filename", "f", "cron.json", "Cron task specification file")
+
+	cronCmd.AddCommand(cronListCmd)
+	cronCmd.AddCommand(cronDeleteCmd)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"cube/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/moby/moby/pkg/stdcopy"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec <task-id> -- <command> [args...]",
+	Short: "Run a command inside a running task.",
+	Long: `cube exec command.
+
+The exec command runs a command inside the container of a running task. The
+session is proxied through the manager, so there is no need to log into the
+worker running the task.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		tty, _ := cmd.Flags().GetBool("tty")
+
+		opts := task.ExecOptions{Cmd: args[1:], Tty: tty, Stdin: interactive}
+		stdinFd, _ := term.GetFdInfo(os.Stdin)
+		if tty {
+			if ws, err := term.GetWinsize(stdinFd); err == nil {
+				opts.Height = uint(ws.Height)
+				opts.Width = uint(ws.Width)
+			}
+		}
+
+		data, err := json.Marshal(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		url := fmt.Sprintf("http://%s/tasks/%s/exec", manager, args[0])
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		conn, resp, err := utils.DialUpgrade(manager, req)
+		if err != nil {
+			log.Fatalf("Error starting exec in task %v: %v", args[0], err)
+		}
+		execID := resp.Header.Get(utils.ExecIDHeader)
+
+		var state *term.State
+		if tty {
+			state, _ = term.SetRawTerminal(stdinFd)
+			go resizeOnWinch(manager, args[0], execID, stdinFd)
+		}
+
+		if interactive {
+			go func() {
+				io.Copy(conn, os.Stdin)
+				conn.CloseWrite()
+			}()
+		}
+
+		if tty {
+			io.Copy(os.Stdout, conn)
+		} else {
+			stdcopy.StdCopy(os.Stdout, os.Stderr, conn)
+		}
+
+		conn.Close()
+		if state != nil {
+			term.RestoreTerminal(stdinFd, state)
+		}
+
+		exitCode := execExitCode(manager, args[0], execID)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	execCmd.Flags().BoolP("interactive", "i", false, "Keep stdin attached to the command")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a terminal for the command")
+}
+
+// resizeOnWinch forwards terminal size changes to the exec session.
+func resizeOnWinch(manager string, taskID string, execID string, fd uintptr) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	for range winch {
+		ws, err := term.GetWinsize(fd)
+		if err != nil {
+			continue
+		}
+		url := fmt.Sprintf("http://%s/tasks/%s/exec/%s/resize?h=%s&w=%s", manager, taskID, execID,
+			strconv.Itoa(int(ws.Height)), strconv.Itoa(int(ws.Width)))
+		resp, err := http.Post(url, "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// execExitCode returns the exit code of a finished exec session, or 1 if it
+// can't be determined.
+func execExitCode(manager string, taskID string, execID string) int {
+	url := fmt.Sprintf("http://%s/tasks/%s/exec/%s", manager, taskID, execID)
+	resp, err := http.Get(url)
+	if err != nil {
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var inspect task.ExecInspectResponse
+	err = json.NewDecoder(resp.Body).Decode(&inspect)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return inspect.ExitCode
+}
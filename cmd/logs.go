@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <task-id>",
+	Short: "Print the logs of a task.",
+	Long: `cube logs command.
+
+The logs command prints the output of a task. The manager fetches the logs
+from the worker the task runs on, so there is no need to log into the worker.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		follow, _ := cmd.Flags().GetBool("follow")
+		tail, _ := cmd.Flags().GetString("tail")
+		since, _ := cmd.Flags().GetString("since")
+		stdout, _ := cmd.Flags().GetBool("stdout")
+		stderr, _ := cmd.Flags().GetBool("stderr")
+
+		q := url.Values{}
+		q.Set("follow", strconv.FormatBool(follow))
+		q.Set("stdout", strconv.FormatBool(stdout))
+		q.Set("stderr", strconv.FormatBool(stderr))
+		if tail != "" {
+			q.Set("tail", tail)
+		}
+		if since != "" {
+			q.Set("since", since)
+		}
+
+		u := fmt.Sprintf("http://%s/tasks/%s/logs?%s", manager, args[0], q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Fatalf("Error making request %v: %v", u, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error getting logs for task %v (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		io.Copy(os.Stdout, resp.Body)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringP("tail", "t", "", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().String("since", "", "Show logs since a timestamp or relative duration (e.g. 10m), docker runtime only")
+	logsCmd.Flags().Bool("stdout", false, "Only show stdout")
+	logsCmd.Flags().Bool("stderr", false, "Only show stderr")
+}
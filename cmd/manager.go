@@ -5,7 +5,10 @@ package cmd
 
 import (
 	"cube/manager"
+	sched "cube/scheduler"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -18,6 +21,7 @@ var managerCmd = &cobra.Command{
 
 The manager controls the orchestration system and is responsible for:
 - Accepting tasks from users
+- Registering workers and tracking their heartbeats
 - Scheduling tasks onto worker nodes
 - Rescheduling tasks in the event of a node failure
 - Periodically polling workers to get task updates`,
@@ -27,12 +31,33 @@ The manager controls the orchestration system and is responsible for:
 		workers, _ := cmd.Flags().GetStringSlice("workers")
 		scheduler, _ := cmd.Flags().GetString("scheduler")
 		dbType, _ := cmd.Flags().GetString("dbType")
+		gracePeriod, _ := cmd.Flags().GetDuration("node-grace-period")
+		weights, _ := cmd.Flags().GetStringToString("binpack-weights")
+		preemption, _ := cmd.Flags().GetBool("preemption")
+		extenderURL, _ := cmd.Flags().GetString("extender-url")
+		extenderTimeout, _ := cmd.Flags().GetDuration("extender-timeout")
+		extenderWeight, _ := cmd.Flags().GetFloat64("extender-weight")
 		log.Println("Starting manager.")
 		m := manager.New(workers, scheduler, dbType)
+		m.NodeGracePeriod = gracePeriod
+		m.Preemption = preemption
+		if bp, ok := m.Scheduler.(*sched.BinPack); ok && len(weights) > 0 {
+			if err := bp.SetWeights(parseWeights(weights)); err != nil {
+				log.Fatalf("invalid --binpack-weights: %v", err)
+			}
+		}
+		if extenderURL != "" {
+			m.Scheduler = sched.NewExtender(m.Scheduler, extenderURL, extenderTimeout, extenderWeight)
+		}
 		api := manager.Api{Address: host, Port: port, Manager: m}
+		go m.CollectStats()
 		go m.ProcessTasks()
 		go m.UpdateTasks()
 		go m.DoHealthChecks()
+		go m.ProcessCronTasks()
+		go m.ReconcileServices()
+		go m.CheckWorkers()
+		go m.DrainNodes()
 		log.Printf("Starting manager API on http://%s:%d", host, port)
 		api.Start()
 	},
@@ -43,6 +68,26 @@ func init() {
 	managerCmd.Flags().StringP("host", "H", "0.0.0.0","Hostname or IP address")
 	managerCmd.Flags().IntP("port", "p", 5555, "Port on which to listen")
 	managerCmd.Flags().StringSliceP("workers", "w",[]string{"localhost:5556"}, "List of workers on which the manager will schedule tasks.")
-	managerCmd.Flags().StringP("scheduler", "s", "greedy", "Name of scheduler to use.")
+	managerCmd.Flags().StringP("scheduler", "s", "greedy", "Name of scheduler to use (\"roundrobin\", \"greedy\", \"epvm\" or \"binpack\").")
 	managerCmd.Flags().StringP("dbType", "d", "memory", "Type of datastore to use for events and tasks (\"memory\" or \"persistent\")")
+	managerCmd.Flags().StringToString("binpack-weights", nil, "Weights of cpu, memory and disk in the binpack scheduler's score, e.g. cpu=2,memory=1,disk=0")
+	managerCmd.Flags().Bool("preemption", false, "Let tasks that fit on no worker evict tasks with a lower priority")
+	managerCmd.Flags().String("extender-url", "", "Base URL of a scheduler extender whose /filter and /score endpoints are called when placing tasks")
+	managerCmd.Flags().Duration("extender-timeout", 2*time.Second, "How long to wait for the scheduler extender before using the built-in results")
+	managerCmd.Flags().Float64("extender-weight", 1, "Weight of the scheduler extender's scores against the built-in ones")
+	managerCmd.Flags().Duration("node-grace-period", manager.DefaultNodeGracePeriod, "How long a worker can fail before its tasks are rescheduled")
+}
+
+// parseWeights converts the values of --binpack-weights to numbers, exiting
+// on the first one that isn't.
+func parseWeights(weights map[string]string) map[string]float64 {
+	parsed := make(map[string]float64)
+	for resource, value := range weights {
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatalf("invalid --binpack-weights: weight of %s is not a number: %q", resource, value)
+		}
+		parsed[resource] = weight
+	}
+	return parsed
 }
@@ -4,13 +4,18 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"bytes"
 	"cube/node"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -21,7 +26,8 @@ var nodeCmd = &cobra.Command{
 	Short: "Node command to list nodes.",
 	Long: `cube node command.
 
-The node command allows a user to get the information about the nodes in the cluster.`,
+The node command allows a user to get the information about the nodes in the cluster.
+Its subcommands cordon, uncordon and drain nodes for maintenance.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		manager, _ := cmd.Flags().GetString("manager")
 
@@ -32,16 +38,243 @@ The node command allows a user to get the information about the nodes in the clu
 		var nodes []*node.Node
 		json.Unmarshal(body, &nodes)
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ',tabwriter.TabIndent)
-		fmt.Fprintln(w, "NAME\tMEMEORY (MiB)\tDISk (GiB)\tROLE\tTASKS\t")
+		fmt.Fprintln(w, "NAME\tSTATUS\tCPU\tMEMEORY (MiB)\tDISk (GiB)\tROLE\tTASKS\tLABELS\tTAINTS\t")
 		for _,node := range nodes {
-			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t\n",node.Name,node.Memory/1000,node.Disk/1000/1000/1000, node.Role,node.TaskCount)
+			fmt.Fprintf(w, "%s\t%s\t%.4g/%.4g\t%d/%d\t%d/%d\t%s\t%d\t%s\t%s\t\n",node.Name,nodeStatus(node),node.CpuAllocated,node.Cpu,node.MemoryAllocated/1024,node.Memory/1024,node.DiskAllocated>>30,node.Disk>>30, node.Role,node.TaskCount,formatLabels(node.Labels),formatTaints(node.Taints))
 		}
 		w.Flush()
 	},
 }
 
 
+var nodeCordonCmd = &cobra.Command{
+	Use:   "cordon <name>",
+	Short: "Stop scheduling tasks on a node.",
+	Long: `cube node cordon command.
+
+The cordon command marks a node unschedulable. Tasks already on the node keep
+running.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		nodeAction(manager, args[0], "cordon")
+		log.Printf("Node %s cordoned", args[0])
+	},
+}
+
+var nodeUncordonCmd = &cobra.Command{
+	Use:   "uncordon <name>",
+	Short: "Allow scheduling tasks on a node again.",
+	Long: `cube node uncordon command.
+
+The uncordon command marks a node schedulable again and cancels its drain.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		nodeAction(manager, args[0], "uncordon")
+		log.Printf("Node %s uncordoned", args[0])
+	},
+}
+
+var nodeDrainCmd = &cobra.Command{
+	Use:   "drain <name>",
+	Short: "Move all tasks off a node.",
+	Long: `cube node drain command.
+
+The drain command cordons a node and moves its tasks to other nodes one at a
+time. Each task is started on another node first, and the copy on the drained
+node is only stopped once the new one is running and passes its health check.
+The command waits until the node is empty.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		n := nodeAction(manager, args[0], "drain")
+		start := time.Now()
+		message := ""
+		for {
+			if n.DrainMessage != message {
+				message = n.DrainMessage
+				log.Printf("Node %s: %s", n.Name, message)
+			}
+			switch n.DrainStatus {
+			case node.Drained:
+				log.Printf("Node %s drained", n.Name)
+				return
+			case node.DrainFailed:
+				log.Fatalf("Draining node %s failed", n.Name)
+			case node.Draining:
+			default:
+				log.Fatalf("Drain of node %s was cancelled", n.Name)
+			}
+			if timeout > 0 && time.Since(start) > timeout {
+				log.Fatalf("Node %s wasn't drained within %v", n.Name, timeout)
+			}
+
+			time.Sleep(5 * time.Second)
+			n = getNode(manager, args[0])
+		}
+	},
+}
+
+var nodeLabelCmd = &cobra.Command{
+	Use:   "label <name> key=value... [key-...]",
+	Short: "Set or remove labels of a node.",
+	Long: `cube node label command.
+
+The label command sets the labels given as key=value on a node and removes
+the ones given as key-. Tasks select the nodes they run on by their labels.
+A worker started with --labels gets its own labels back when it registers
+again.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		set := map[string]string{}
+		remove := []string{}
+		for _, arg := range args[1:] {
+			if k, v, ok := strings.Cut(arg, "="); ok {
+				set[k] = v
+			} else if strings.HasSuffix(arg, "-") {
+				remove = append(remove, strings.TrimSuffix(arg, "-"))
+			} else {
+				log.Fatalf("Invalid label %q, expected key=value or key-", arg)
+			}
+		}
+		data, _ := json.Marshal(map[string]interface{}{"Set": set, "Remove": remove})
+
+		url := fmt.Sprintf("http://%s/nodes/%s/labels", manager, args[0])
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		n := decodeNode(resp, args[0])
+		log.Printf("Node %s labels: %s", n.Name, formatLabels(n.Labels))
+	},
+}
+
+var nodeTaintCmd = &cobra.Command{
+	Use:   "taint <name> key=value:Effect... [key:Effect-|key-...]",
+	Short: "Add or remove taints of a node.",
+	Long: `cube node taint command.
+
+The taint command adds the taints given as key=value:Effect or key:Effect to
+a node, and removes the ones given as key:Effect- or, for all effects, key-.
+The effects are NoSchedule, PreferNoSchedule and NoExecute. Only tasks that
+tolerate a taint are placed on the node, and a NoExecute taint also evicts
+the running tasks that don't tolerate it.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		add := []node.Taint{}
+		remove := []node.Taint{}
+		for _, arg := range args[1:] {
+			if strings.HasSuffix(arg, "-") {
+				key, effect, _ := strings.Cut(strings.TrimSuffix(arg, "-"), ":")
+				remove = append(remove, node.Taint{Key: key, Effect: effect})
+				continue
+			}
+			taint, err := node.ParseTaint(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			add = append(add, taint)
+		}
+		data, _ := json.Marshal(map[string][]node.Taint{"Add": add, "Remove": remove})
+
+		url := fmt.Sprintf("http://%s/nodes/%s/taints", manager, args[0])
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		n := decodeNode(resp, args[0])
+		log.Printf("Node %s taints: %s", n.Name, formatTaints(n.Taints))
+	},
+}
+
+func nodeAction(manager string, name string, action string) *node.Node {
+	url := fmt.Sprintf("http://%s/nodes/%s/%s", manager, name, action)
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return decodeNode(resp, name)
+}
+
+func getNode(manager string, name string) *node.Node {
+	url := fmt.Sprintf("http://%s/nodes/%s", manager, name)
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return decodeNode(resp, name)
+}
+
+func decodeNode(resp *http.Response, name string) *node.Node {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error for node %s (%d): %s", name, resp.StatusCode, body)
+	}
+
+	var n node.Node
+	err := json.Unmarshal(body, &n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &n
+}
+
+// nodeStatus prints the status of a node along with its scheduling and
+// drain state.
+func nodeStatus(n *node.Node) string {
+	status := n.Status
+	if n.Unschedulable {
+		status += ",Unschedulable"
+	}
+	if n.DrainStatus != "" {
+		status += "," + n.DrainStatus
+	}
+	return status
+}
+
+// formatLabels prints labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// formatTaints prints taints in key=value:Effect form.
+func formatTaints(taints []node.Taint) string {
+	if len(taints) == 0 {
+		return "-"
+	}
+	var formatted []string
+	for _, taint := range taints {
+		formatted = append(formatted, taint.String())
+	}
+	return strings.Join(formatted, ",")
+}
+
 func init() {
 	rootCmd.AddCommand(nodeCmd)
-	nodeCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	nodeCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+
+	nodeCmd.AddCommand(nodeCordonCmd)
+	nodeCmd.AddCommand(nodeUncordonCmd)
+	nodeCmd.AddCommand(nodeDrainCmd)
+	nodeCmd.AddCommand(nodeLabelCmd)
+	nodeCmd.AddCommand(nodeTaintCmd)
+	nodeDrainCmd.Flags().Duration("timeout", 0, "How long to wait for the node to be drained, no limit if 0")
 }
\ No newline at end of file
@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+// rolloutCmd represents the rollout command
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "Manage the rollout of a service.",
+	Long: `cube rollout command.
+
+Updating the task template of a service creates a new revision, which
+replaces the tasks of the old one a few at a time. The rollout commands show
+its progress and revision history, resume a rollout paused after a failure
+and restore earlier revisions.`,
+}
+
+var rolloutStatusCmd = &cobra.Command{
+	Use:   "status <service>",
+	Short: "Show the rollout status of a service.",
+	Long: `cube rollout status command.
+
+The status command shows the current revision of a service and the state of
+its rollout.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		s := getService(manager, args[0])
+		fmt.Printf("Revision:  %d\n", s.Revision)
+		fmt.Printf("Status:    %s\n", s.Rollout.Status)
+		if s.Rollout.Message != "" {
+			fmt.Printf("Message:   %s\n", s.Rollout.Message)
+		}
+		fmt.Printf("Replicas:  %d desired, %d current\n", s.Replicas, len(s.Tasks))
+	},
+}
+
+var rolloutHistoryCmd = &cobra.Command{
+	Use:   "history <service>",
+	Short: "Show the revision history of a service.",
+	Long: `cube rollout history command.
+
+The history command lists the revisions of a service that are kept by the
+manager.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		s := getService(manager, args[0])
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "REVISION\tIMAGE\tCREATED\t")
+		for _, r := range s.Revisions {
+			revision := fmt.Sprintf("%d", r.Revision)
+			if r.Revision == s.Revision {
+				revision += " (current)"
+			}
+			created := fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(r.CreatedTime)))
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", revision, r.Task.Image, created)
+		}
+		w.Flush()
+	},
+}
+
+var rolloutUndoCmd = &cobra.Command{
+	Use:   "undo <service>",
+	Short: "Roll a service back to an earlier revision.",
+	Long: `cube rollout undo command.
+
+The undo command restores the task template of the previous revision, or of
+the one given with --to-revision, and rolls it out as a new revision.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		revision, _ := cmd.Flags().GetInt("to-revision")
+
+		data, _ := json.Marshal(map[string]int{"Revision": revision})
+		s := postRollout(manager, args[0], "rollback", data)
+		log.Printf("Service %s: %s", s.Name, s.Rollout.Message)
+	},
+}
+
+var rolloutResumeCmd = &cobra.Command{
+	Use:   "resume <service>",
+	Short: "Resume a paused rollout.",
+	Long: `cube rollout resume command.
+
+The resume command continues a rollout that was paused after a failure.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		s := postRollout(manager, args[0], "resume", nil)
+		log.Printf("Service %s: %s", s.Name, s.Rollout.Message)
+	},
+}
+
+func getService(manager string, name string) *task.Service {
+	url := fmt.Sprintf("http://%s/services/%s", manager, name)
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error getting service %s (%d): %s", name, resp.StatusCode, body)
+	}
+
+	var s task.Service
+	err = json.Unmarshal(body, &s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &s
+}
+
+func postRollout(manager string, name string, action string, data []byte) *task.Service {
+	url := fmt.Sprintf("http://%s/services/%s/%s", manager, name, action)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error running %s on service %s (%d): %s", action, name, resp.StatusCode, body)
+	}
+
+	var s task.Service
+	json.Unmarshal(body, &s)
+	return &s
+}
+
+func init() {
+	rootCmd.AddCommand(rolloutCmd)
+	rolloutCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+
+	rolloutCmd.AddCommand(rolloutStatusCmd)
+	rolloutCmd.AddCommand(rolloutHistoryCmd)
+	rolloutCmd.AddCommand(rolloutUndoCmd)
+	rolloutUndoCmd.Flags().Int("to-revision", 0, "Revision to roll back to, the previous one if 0")
+	rolloutCmd.AddCommand(rolloutResumeCmd)
+}
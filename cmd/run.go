@@ -5,13 +5,18 @@ package cmd
 
 import (
 	"bytes"
+	managerpkg "cube/manager"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 )
@@ -22,10 +27,13 @@ var runCmd = &cobra.Command{
 	Short: "Run a new task.",
 	Long: `cube run command.
 
-The run command starts a new task.`,
+The run command starts a new task. With --dry-run it only shows where the
+task would be placed, with the score of every candidate node and why the
+other nodes were filtered out.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		manager,_ := cmd.Flags().GetString("manager")
 		filename,_ := cmd.Flags().GetString("filename")
+		dryRun,_ := cmd.Flags().GetBool("dry-run")
 
 		fullFilePath,err := filepath.Abs(filename)
 		if err != nil {
@@ -43,6 +51,11 @@ The run command starts a new task.`,
 
 		log.Printf("Data: %v",string(data))
 
+		if dryRun {
+			simulate(manager, data)
+			return
+		}
+
 		url := fmt.Sprintf("http://%s/tasks",manager)
 		resp,err := http.Post(url,"application/json",bytes.NewBuffer(data))
 		if err != nil {
@@ -63,6 +76,52 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
 	runCmd.Flags().StringP("filename", "f", "task.json", "Task specification file")
+	runCmd.Flags().Bool("dry-run", false, "Show where the task would be placed without starting it")
+}
+
+// simulate asks the manager where the task would be placed and prints the
+// answer.
+func simulate(manager string, data []byte) {
+	url := fmt.Sprintf("http://%s/tasks/simulate", manager)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error simulating task (%d): %s", resp.StatusCode, body)
+	}
+
+	var result managerpkg.Simulation
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Fatal(err)
+	}
+	if result.Node != "" {
+		fmt.Printf("Task would be placed on %s\n\n", result.Node)
+	} else {
+		fmt.Printf("Task can't be placed: %s\n\n", result.Error)
+	}
+
+	var names []string
+	for name := range result.Scores {
+		names = append(names, name)
+	}
+	for name := range result.Reasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "NODE\tSCORE\tREASON\t")
+	for _, name := range names {
+		if reason, ok := result.Reasons[name]; ok {
+			fmt.Fprintf(w, "%s\t-\t%s\t\n", name, reason)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%.4g\t-\t\n", name, result.Scores[name])
+	}
+	w.Flush()
 }
 
 func fileExists(filename string) bool {
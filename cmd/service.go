@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage services.",
+	Long: `cube service command.
+
+A service keeps a number of replicas of its task running. The manager replaces
+replicas that fail or are stopped and starts or stops replicas when the
+service is scaled.`,
+}
+
+var serviceCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a service.",
+	Long: `cube service create command.
+
+The create command registers a new service with the manager from a service
+specification file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		fullFilePath, err := filepath.Abs(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !fileExists(fullFilePath) {
+			log.Fatalf("File %s does not exist", filename)
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		url := fmt.Sprintf("http://%s/services", manager)
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error creating service (%d): %s", resp.StatusCode, body)
+		}
+
+		var service task.Service
+		json.Unmarshal(body, &service)
+		log.Printf("Created service %s (%v) with %d replicas", service.Name, service.ID, service.Replicas)
+	},
+}
+
+var serviceUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update a service.",
+	Long: `cube service update command.
+
+The update command applies a service specification file to the existing
+service of the same name. A changed task template is rolled out gradually,
+see cube rollout.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		fullFilePath, err := filepath.Abs(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !fileExists(fullFilePath) {
+			log.Fatalf("File %s does not exist", filename)
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var spec task.Service
+		err = json.Unmarshal(data, &spec)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/services/%s", manager, spec.Name)
+		req, err := http.NewRequest("PUT", url, bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error updating service (%d): %s", resp.StatusCode, body)
+		}
+
+		var service task.Service
+		json.Unmarshal(body, &service)
+		log.Printf("Updated service %s, revision %d: %s", service.Name, service.Revision, service.Rollout.Status)
+	},
+}
+
+var serviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List services.",
+	Long: `cube service list command.
+
+The list command shows the services known to the manager with their desired
+and current number of replicas.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		url := fmt.Sprintf("http://%s/services", manager)
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var services []*task.Service
+		err = json.NewDecoder(resp.Body).Decode(&services)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tIMAGE\tREPLICAS\tCURRENT\tREVISION\tROLLOUT\t")
+		for _, s := range services {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t\n", s.ID, s.Name, s.Task.Image, s.Replicas, len(s.Tasks), s.Revision, s.Rollout.Status)
+		}
+		w.Flush()
+	},
+}
+
+var serviceScaleCmd = &cobra.Command{
+	Use:   "scale <service> <replicas>",
+	Short: "Scale a service.",
+	Long: `cube service scale command.
+
+The scale command changes the desired number of replicas of a service, given
+by name or ID. The manager starts or stops tasks to match it.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		replicas, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("Invalid number of replicas %q", args[1])
+		}
+
+		data, _ := json.Marshal(map[string]int{"Replicas": replicas})
+		url := fmt.Sprintf("http://%s/services/%s/scale", manager, args[0])
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error scaling service %v (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		log.Printf("Service %v scaled to %d replicas", args[0], replicas)
+	},
+}
+
+var serviceDeleteCmd = &cobra.Command{
+	Use:   "delete <service>",
+	Short: "Delete a service.",
+	Long: `cube service delete command.
+
+The delete command stops the tasks of a service and removes it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		url := fmt.Sprintf("http://%s/services/%s", manager, args[0])
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error making request %v: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			body, _ := io.ReadAll(resp.Body)
+			log.Fatalf("Error deleting service %v (%d): %s", args[0], resp.StatusCode, body)
+		}
+
+		log.Printf("Service %v has been deleted", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+
+	serviceCmd.AddCommand(serviceCreateCmd)
+	serviceCreateCmd.Flags().StringP("filename", "f", "service.json", "Service specification file")
+
+	serviceCmd.AddCommand(serviceUpdateCmd)
+	serviceUpdateCmd.Flags().StringP("filename", "f", "service.json", "Service specification file")
+
+	serviceCmd.AddCommand(serviceListCmd)
+	serviceCmd.AddCommand(serviceScaleCmd)
+	serviceCmd.AddCommand(serviceDeleteCmd)
+}
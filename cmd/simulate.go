@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	sched "cube/scheduler"
+	"cube/simulator"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Compare schedulers on a synthetic cluster.",
+	Long: `cube simulate command.
+
+The simulate command plays a trace of task submissions against a synthetic
+cluster with each scheduler, without a manager or workers, and reports how
+well it placed them: how long tasks waited, how many were never placed, how
+much of the cluster was allocated and how fragmented its free capacity was.
+
+The cluster is a JSON file of node specs, the trace a JSONL file with one
+submission per line.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterFile, _ := cmd.Flags().GetString("cluster")
+		traceFile, _ := cmd.Flags().GetString("trace")
+		schedulers, _ := cmd.Flags().GetStringSlice("schedulers")
+		weights, _ := cmd.Flags().GetStringToString("binpack-weights")
+
+		cluster, err := simulator.LoadCluster(clusterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trace, err := simulator.LoadTrace(traceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "SCHEDULER\tPLACED\tUNSCHEDULABLE\tAVG PENDING\tMAX PENDING\tCPU UTIL\tMEM UTIL\tFRAGMENTATION\t")
+		for _, name := range schedulers {
+			s, err := sched.New(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if bp, ok := s.(*sched.BinPack); ok && len(weights) > 0 {
+				if err := bp.SetWeights(parseWeights(weights)); err != nil {
+					log.Fatalf("invalid --binpack-weights: %v", err)
+				}
+			}
+
+			r := simulator.Run(cluster, trace, s)
+			fmt.Fprintf(w, "%s\t%d/%d\t%d\t%.1fs\t%.1fs\t%.1f%%\t%.1f%%\t%.1f%%\t\n",
+				name, r.Placed, r.Tasks, r.Unschedulable, r.AvgPending, r.MaxPending,
+				r.CpuUtilisation*100, r.MemoryUtilisation*100, r.Fragmentation*100)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringP("cluster", "c", "cluster.json", "Cluster description file")
+	simulateCmd.Flags().StringP("trace", "t", "trace.jsonl", "Trace of task submissions")
+	simulateCmd.Flags().StringSlice("schedulers", sched.Names, "Schedulers to compare")
+	simulateCmd.Flags().StringToString("binpack-weights", nil, "Weights of cpu, memory and disk in the binpack scheduler's score, e.g. cpu=2,memory=1,disk=0")
+}
@@ -4,9 +4,11 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cube/node"
 	"cube/worker"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
@@ -17,19 +19,39 @@ var workerCmd = &cobra.Command{
 	Use:   "worker",
 	Short: "Worker command to operate a Cube worker node.",
 	Long: `cube worker command.
-	The worker runs tasks and responds to the manager's requests about task state.`,
+	The worker runs tasks and responds to the manager's requests about task state.
+	Started with --manager, it registers itself with the manager and sends it heartbeats.`,
 	Run: func(cmd *cobra.Command, args []string) {
     host, _ := cmd.Flags().GetString("host")
     port, _ := cmd.Flags().GetInt("port")
     name, _ := cmd.Flags().GetString("name")
     dbType, _ := cmd.Flags().GetString("dbtype")
+    runtime, _ := cmd.Flags().GetString("runtime")
+    manager, _ := cmd.Flags().GetString("manager")
+    advertise, _ := cmd.Flags().GetString("advertise")
+    labels, _ := cmd.Flags().GetStringToString("labels")
+    taintSpecs, _ := cmd.Flags().GetStringSlice("taints")
+    var taints []node.Taint
+    for _, spec := range taintSpecs {
+      taint, err := node.ParseTaint(spec)
+      if err != nil {
+        log.Fatalf("invalid --taints: %v", err)
+      }
+      taints = append(taints, taint)
+    }
 
 		log.Println("Starting worker.")
-    w := worker.New(name, dbType)
+    w := worker.New(name, dbType, runtime)
     api := worker.Api{Address: host, Port: port, Worker: w}
     go w.RunTasks()
     go w.CollectStats()
     go w.UpdateTasks()
+    if manager != "" {
+      if advertise == "" {
+        advertise = advertiseAddress(host, port)
+      }
+      go w.SendHeartbeats(manager, advertise, labels, taints)
+    }
     log.Printf("Starting worker API on http://%s:%d", host, port)
     api.Start()
 	},
@@ -43,4 +65,21 @@ func init() {
 	workerCmd.Flags().IntP("port", "p", 5556, "Port on which to listen")
 	workerCmd.Flags().StringP("name", "n", fmt.Sprintf("worker-%s", uuid.New().String()), "Name of the worker")
   workerCmd.Flags().StringP("dbtype", "d", "memory", "Type of datastore to use for tasks (\"memory\" or \"persistent\")")
+  workerCmd.Flags().StringP("runtime", "r", "docker", "Default runtime used to run tasks (\"docker\", \"exec\" or \"memory\")")
+  workerCmd.Flags().StringP("manager", "m", "", "Manager to register with, the worker doesn't register if empty")
+  workerCmd.Flags().StringP("advertise", "a", "", "Address the manager reaches the worker at, the host name and port if empty")
+  workerCmd.Flags().StringToStringP("labels", "l", nil, "Labels of the worker in key=value form")
+  workerCmd.Flags().StringSlice("taints", nil, "Taints of the worker in key=value:Effect form, the effect being NoSchedule, PreferNoSchedule or NoExecute")
+}
+
+// advertiseAddress is the address the worker registers with when none is
+// given. A worker listening on all interfaces is reached by its host name.
+func advertiseAddress(host string, port int) string {
+	if host == "" || host == "0.0.0.0" {
+		hostname, err := os.Hostname()
+		if err == nil {
+			host = hostname
+		}
+	}
+	return fmt.Sprintf("%s:%d", host, port)
 }
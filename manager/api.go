@@ -25,11 +25,39 @@ func (a *Api) initRouter() {
 	a.Router.Route("/tasks",func(router chi.Router){
 		router.Post("/",a.StartTaskHandler)
 		router.Get("/",a.GetTasksHandler)
+		router.Post("/simulate", a.SimulateTaskHandler)
 		router.Route("/{taskId}",func(router chi.Router) {
 			router.Delete("/", a.StopTaskHandler)
+			router.Get("/logs", a.GetTaskLogsHandler)
+			router.Post("/exec", a.ExecTaskHandler)
+			router.Get("/exec/{execId}", a.InspectExecHandler)
+			router.Post("/exec/{execId}/resize", a.ResizeExecHandler)
 		})
 		a.Router.Route("/nodes", func(r chi.Router) {
 			r.Get("/", a.GetNodesHandler)
+			r.Post("/", a.RegisterNodeHandler)
+			r.Put("/{nodeName}/heartbeat", a.HeartbeatHandler)
+			r.Get("/{nodeName}", a.GetNodeHandler)
+			r.Post("/{nodeName}/cordon", a.CordonNodeHandler)
+			r.Post("/{nodeName}/uncordon", a.UncordonNodeHandler)
+			r.Post("/{nodeName}/drain", a.DrainNodeHandler)
+			r.Post("/{nodeName}/labels", a.LabelNodeHandler)
+			r.Post("/{nodeName}/taints", a.TaintNodeHandler)
+		})
+		a.Router.Route("/crons", func(r chi.Router) {
+			r.Post("/", a.CreateCronTaskHandler)
+			r.Get("/", a.GetCronTasksHandler)
+			r.Delete("/{cronId}", a.DeleteCronTaskHandler)
+		})
+		a.Router.Route("/services", func(r chi.Router) {
+			r.Post("/", a.CreateServiceHandler)
+			r.Get("/", a.GetServicesHandler)
+			r.Get("/{service}", a.GetServiceHandler)
+			r.Put("/{service}", a.UpdateServiceHandler)
+			r.Post("/{service}/scale", a.ScaleServiceHandler)
+			r.Post("/{service}/rollback", a.RollbackServiceHandler)
+			r.Post("/{service}/resume", a.ResumeServiceHandler)
+			r.Delete("/{service}", a.DeleteServiceHandler)
 		})
 	})
 }
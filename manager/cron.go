@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSuccessfulHistoryLimit = 3
+	defaultFailedHistoryLimit     = 1
+)
+
+func (m *Manager) AddCronTask(c *task.CronTask) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	if c.ConcurrencyPolicy == "" {
+		c.ConcurrencyPolicy = task.ConcurrencyAllow
+	}
+	if c.SuccessfulHistoryLimit == nil {
+		limit := defaultSuccessfulHistoryLimit
+		c.SuccessfulHistoryLimit = &limit
+	}
+	if c.FailedHistoryLimit == nil {
+		limit := defaultFailedHistoryLimit
+		c.FailedHistoryLimit = &limit
+	}
+	c.CreatedTime = time.Now().UTC()
+	c.LastScheduleTime = time.Time{}
+	c.Active = nil
+	c.Successful = nil
+	c.Failed = nil
+
+	return m.CronDb.Put(c.ID.String(), c)
+}
+
+func (m *Manager) GetCronTasks() []*task.CronTask {
+	cronList, err := m.CronDb.List()
+	if err != nil {
+		log.Printf("error getting list of cron tasks: %v\n", err)
+		return nil
+	}
+
+	return cronList.([]*task.CronTask)
+}
+
+// DeleteCronTask removes the cron task so that it isn't run again. Runs that
+// are already active keep running.
+func (m *Manager) DeleteCronTask(id string) error {
+	_, err := m.CronDb.Get(id)
+	if err != nil {
+		return err
+	}
+	return m.CronDb.Delete(id)
+}
+
+func (m *Manager) ProcessCronTasks() {
+	for {
+		m.mu.Lock()
+		m.processCronTasks()
+		m.mu.Unlock()
+		m.sendStops()
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) processCronTasks() {
+	now := time.Now().UTC()
+	for _, c := range m.GetCronTasks() {
+		m.updateCronHistory(c)
+
+		next, err := c.Next()
+		if err != nil {
+			log.Printf("error computing next run of cron task %s: %v\n", c.Name, err)
+			continue
+		}
+
+		if !next.After(now) {
+			// Runs missed while the manager was down are collapsed into this one.
+			c.LastScheduleTime = now
+			m.runCronTask(c, next)
+		}
+
+		m.CronDb.Put(c.ID.String(), c)
+	}
+}
+
+func (m *Manager) runCronTask(c *task.CronTask, scheduled time.Time) {
+	if len(c.Active) > 0 {
+		switch c.ConcurrencyPolicy {
+		case task.ConcurrencyForbid:
+			log.Printf("cron task %s still has %d active runs, skipping run scheduled at %v\n", c.Name, len(c.Active), scheduled)
+			return
+		case task.ConcurrencyReplace:
+			for _, id := range c.Active {
+				m.requestStop(id)
+			}
+		}
+	}
+
+	t := c.Task
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%d", c.Name, scheduled.Unix())
+	t.State = task.Scheduled
+	if t.Type == "" {
+		t.Type = task.TypeJob
+	}
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+	})
+	c.Active = append(c.Active, t.ID)
+	log.Printf("cron task %s started run %s scheduled at %v\n", c.Name, t.ID, scheduled)
+}
+
+// updateCronHistory moves finished runs out of Active and deletes the runs
+// that fall outside the history limits.
+func (m *Manager) updateCronHistory(c *task.CronTask) {
+	var active []uuid.UUID
+	for _, id := range c.Active {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			// not scheduled yet
+			active = append(active, id)
+			continue
+		}
+
+		switch result.(*task.Task).State {
+		case task.Completed:
+			c.Successful = append(c.Successful, id)
+		case task.Failed:
+			c.Failed = append(c.Failed, id)
+		default:
+			active = append(active, id)
+		}
+	}
+	c.Active = active
+
+	c.Successful = m.trimHistory(c.Successful, *c.SuccessfulHistoryLimit)
+	c.Failed = m.trimHistory(c.Failed, *c.FailedHistoryLimit)
+}
+
+func (m *Manager) trimHistory(ids []uuid.UUID, limit int) []uuid.UUID {
+	if len(ids) <= limit {
+		return ids
+	}
+
+	for _, id := range ids[:len(ids)-limit] {
+		m.forgetTask(id)
+	}
+	return ids[len(ids)-limit:]
+}
+
+// forgetTask deletes a finished task from the manager. Its worker is told to
+// remove the task too, along with the workload and logs it kept for it.
+func (m *Manager) forgetTask(id uuid.UUID) {
+	if w, ok := m.TaskWorkerMap[id]; ok {
+		m.stopLater(w, id)
+	}
+	m.TaskDb.Delete(id.String())
+	m.unassignTask(id)
+}
+
+// unassignTask removes a task from the worker it was assigned to.
+func (m *Manager) unassignTask(id uuid.UUID) {
+	w, ok := m.TaskWorkerMap[id]
+	if !ok {
+		return
+	}
+	delete(m.TaskWorkerMap, id)
+
+	var ids []uuid.UUID
+	for _, tID := range m.WorkerTaskMap[w] {
+		if tID != id {
+			ids = append(ids, tID)
+		}
+	}
+	m.WorkerTaskMap[w] = ids
+}
@@ -0,0 +1,250 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cube/node"
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// CordonNode stops new tasks from being scheduled on a node.
+func (m *Manager) CordonNode(name string) (*node.Node, error) {
+	n := m.getNode(name)
+	if n == nil {
+		return nil, fmt.Errorf("node %s not found", name)
+	}
+
+	if !n.Unschedulable {
+		log.Printf("cordoning node %s\n", name)
+	}
+	n.Unschedulable = true
+	return n, nil
+}
+
+// UncordonNode allows tasks to be scheduled on a node again and cancels its
+// drain. A task that is being moved off the node finishes moving.
+func (m *Manager) UncordonNode(name string) (*node.Node, error) {
+	n := m.getNode(name)
+	if n == nil {
+		return nil, fmt.Errorf("node %s not found", name)
+	}
+
+	if n.Unschedulable {
+		log.Printf("uncordoning node %s\n", name)
+	}
+	n.Unschedulable = false
+	n.DrainStatus = ""
+	n.DrainMessage = ""
+	return n, nil
+}
+
+// DrainNode cordons a node and moves its tasks to other nodes one at a time.
+// Each task is started elsewhere first, and the copy on the drained node is
+// only stopped once the new one is running and passes its health check.
+func (m *Manager) DrainNode(name string) (*node.Node, error) {
+	n, err := m.CordonNode(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.DrainStatus != node.Draining {
+		log.Printf("draining node %s\n", name)
+		n.DrainStatus = node.Draining
+		n.DrainMessage = ""
+		m.drainNode(n, nil)
+	}
+	return n, nil
+}
+
+func (m *Manager) DrainNodes() {
+	for {
+		m.drainNodes()
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// drainNodes takes the next step of every drain. The tasks being moved are
+// health checked, and the drained workers asked which tasks they still run,
+// without the manager lock.
+func (m *Manager) drainNodes() {
+	m.mu.Lock()
+	var probes []healthProbe
+	for id := range m.Moving {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		if p, ok := m.healthProbeOf(result.(*task.Task)); ok {
+			probes = append(probes, p)
+		}
+	}
+	var draining []string
+	for _, n := range m.WorkerNodes {
+		if n.DrainStatus == node.Draining {
+			draining = append(draining, n.Name)
+		}
+	}
+	m.mu.Unlock()
+
+	m.probeHealth(probes)
+	running := make(map[string]workerTasks)
+	for _, w := range draining {
+		count, err := runningOnWorker(w)
+		running[w] = workerTasks{running: count, err: err}
+	}
+
+	m.mu.Lock()
+	for _, n := range m.WorkerNodes {
+		m.drainNode(n, running)
+	}
+	m.mu.Unlock()
+}
+
+// workerTasks is how many tasks a worker has scheduled or running, or why it
+// couldn't tell.
+type workerTasks struct {
+	running int
+	err     error
+}
+
+// drainNode checks on the task being moved off a node and, once it has
+// moved, starts moving the next one. running holds what the drained workers
+// reported, a node missing from it waits for the next pass.
+func (m *Manager) drainNode(n *node.Node, running map[string]workerTasks) {
+	for id, w := range m.Moving {
+		if w == n.Name {
+			m.checkMove(n, id)
+			return
+		}
+	}
+	if n.DrainStatus != node.Draining {
+		return
+	}
+
+	for _, id := range m.WorkerTaskMap[n.Name] {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if t.State == task.Scheduled || t.State == task.Running {
+			m.startMove(n, t)
+			return
+		}
+	}
+
+	// the copies of moved tasks are stopped by updateTasks, the node is only
+	// empty once the worker stopped all of them
+	reported, ok := running[n.Name]
+	if !ok {
+		return
+	}
+	if reported.err != nil {
+		n.DrainMessage = fmt.Sprintf("waiting for node: %v", reported.err)
+		return
+	}
+	if reported.running > 0 {
+		n.DrainMessage = fmt.Sprintf("waiting for %d tasks to stop", reported.running)
+		return
+	}
+
+	log.Printf("node %s is drained\n", n.Name)
+	n.DrainStatus = node.Drained
+	n.DrainMessage = "no tasks left on the node"
+}
+
+// runningOnWorker asks a worker how many of its tasks are scheduled or
+// running.
+func runningOnWorker(worker string) (int, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/tasks", worker))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var tasks []*task.Task
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	if err != nil {
+		return 0, err
+	}
+
+	running := 0
+	for _, t := range tasks {
+		if t.State == task.Scheduled || t.State == task.Running {
+			running++
+		}
+	}
+	return running, nil
+}
+
+// startMove queues a task for scheduling on another node. Until the move is
+// done, updates about the task from the drained node are ignored.
+func (m *Manager) startMove(n *node.Node, t *task.Task) {
+	log.Printf("moving task %s off node %s\n", t.ID, n.Name)
+	m.unassignTask(t.ID)
+	m.Moving[t.ID] = n.Name
+	n.DrainMessage = fmt.Sprintf("moving task %s", t.ID)
+
+	moved := *t
+	moved.State = task.Scheduled
+	moved.ContainerID = ""
+	moved.HostPorts = nil
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now().UTC(),
+		Task:      moved,
+	})
+}
+
+// checkMove finishes moving a task once its new copy is ready, by leaving
+// the old copy to be stopped as a duplicate. If the new copy fails, the task
+// is handed back to the old copy and the drain fails.
+func (m *Manager) checkMove(n *node.Node, id uuid.UUID) {
+	w, ok := m.TaskWorkerMap[id]
+	if !ok {
+		// not scheduled yet
+		return
+	}
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		delete(m.Moving, id)
+		return
+	}
+	t := result.(*task.Task)
+
+	switch {
+	case m.taskReady(t, 0) || (t.IsJob() && t.State == task.Completed):
+		log.Printf("task %s moved from node %s to %s\n", id, n.Name, w)
+		delete(m.Moving, id)
+		m.LostTasks[id] = n.Name
+	case t.State == task.Completed || t.State == task.Failed:
+		log.Printf("task %s is %v on node %s, keeping it on node %s\n", id, t.State, w, n.Name)
+		m.unassignTask(id)
+		m.LostTasks[id] = w
+		t.State = task.Running
+		t.Error = ""
+		m.TaskDb.Put(id.String(), t)
+		m.cancelMove(n, id, fmt.Sprintf("task %s failed to start on node %s", id, w))
+	}
+}
+
+// cancelMove hands a task that couldn't be moved back to the copy still
+// running on the drained node, and fails the drain with reason.
+func (m *Manager) cancelMove(n *node.Node, id uuid.UUID, reason string) {
+	delete(m.Moving, id)
+	m.TaskWorkerMap[id] = n.Name
+	m.WorkerTaskMap[n.Name] = append(m.WorkerTaskMap[n.Name], id)
+
+	if n.DrainStatus == node.Draining {
+		n.DrainStatus = node.DrainFailed
+		n.DrainMessage = reason
+	}
+}
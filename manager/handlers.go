@@ -1,9 +1,12 @@
 package manager
 
 import (
+	"cube/node"
 	"cube/task"
+	"cube/utils"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -12,6 +15,19 @@ import (
 	"github.com/google/uuid"
 )
 
+// validateTask checks the parts of a task's spec the manager can check
+// before placing it.
+func validateTask(t *task.Task) error {
+	_, err := task.ParsePortBindings(t.PortBindings)
+	if err == nil {
+		err = task.ValidateMounts(t.Mounts)
+	}
+	if err == nil {
+		err = task.ValidatePlacement(t)
+	}
+	return err
+}
+
 func (a *Api) StartTaskHandler(res http.ResponseWriter,req *http.Request) {
 	data := json.NewDecoder(req.Body)
 	data.DisallowUnknownFields()
@@ -31,13 +47,54 @@ func (a *Api) StartTaskHandler(res http.ResponseWriter,req *http.Request) {
 		return
 	}
 
+	err = validateTask(&taskEvent.Task)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid task: %v\n", err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message: msg,
+		}
+		json.NewEncoder(res).Encode(e)
+		return
+	}
+
+	a.Manager.mu.Lock()
 	a.Manager.AddTask(taskEvent)
+	a.Manager.mu.Unlock()
 	log.Printf("Added task %v\n", taskEvent.Task.ID)
 	res.WriteHeader(201)
 	json.NewEncoder(res).Encode(taskEvent.Task)
 }
 
+// SimulateTaskHandler reports where the task in the request would be placed,
+// without starting it.
+func (a *Api) SimulateTaskHandler(res http.ResponseWriter, req *http.Request) {
+	data := json.NewDecoder(req.Body)
+	data.DisallowUnknownFields()
+
+	taskEvent := task.TaskEvent{}
+	err := data.Decode(&taskEvent)
+	if err == nil {
+		err = validateTask(&taskEvent.Task)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Invalid task: %v\n", err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(a.Manager.Simulate(taskEvent.Task))
+}
+
 func (a *Api) GetTasksHandler(res http.ResponseWriter,req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
 	res.Header().Set("Content-Type","application/json");
 	res.WriteHeader(200)
 	json.NewEncoder(res).Encode(a.Manager.GetTasks())
@@ -50,6 +107,8 @@ func (a *Api)StopTaskHandler(res http.ResponseWriter,req *http.Request) {
 		res.WriteHeader(400)
 	}
 
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
 	tID,_ := uuid.Parse(taskId)
 	result, ok := a.Manager.TaskDb.Get(tID.String());
 	if ok != nil {
@@ -76,7 +135,558 @@ func (a *Api)StopTaskHandler(res http.ResponseWriter,req *http.Request) {
 }
 
 func (a *Api) GetNodesHandler(res http.ResponseWriter, req *http.Request){
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	a.Manager.updateNodeAllocations()
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(200)
 	json.NewEncoder(res).Encode(a.Manager.WorkerNodes)
-}
\ No newline at end of file
+}
+
+func (a *Api) RegisterNodeHandler(res http.ResponseWriter, req *http.Request) {
+	data := json.NewDecoder(req.Body)
+	data.DisallowUnknownFields()
+
+	r := node.Registration{}
+	err := data.Decode(&r)
+	var n *node.Node
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	if err == nil {
+		n, err = a.Manager.RegisterWorker(r)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error registering worker: %v\n", err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+	a.Manager.updateNodeAllocations()
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(n)
+}
+
+func (a *Api) HeartbeatHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	err := a.Manager.Heartbeat(chi.URLParam(req, "nodeName"))
+	if err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	res.WriteHeader(204)
+}
+
+func (a *Api) GetNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	n := a.Manager.getNode(chi.URLParam(req, "nodeName"))
+	if n == nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: "node not found"})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(n)
+}
+
+func (a *Api) CordonNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.nodeAction(res, req, a.Manager.CordonNode)
+}
+
+func (a *Api) UncordonNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.nodeAction(res, req, a.Manager.UncordonNode)
+}
+
+func (a *Api) DrainNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.nodeAction(res, req, a.Manager.DrainNode)
+}
+
+// LabelRequest is the body of a request to change the labels of a node.
+type LabelRequest struct {
+	Set    map[string]string
+	Remove []string
+}
+
+func (a *Api) LabelNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	name := chi.URLParam(req, "nodeName")
+	if a.Manager.getNode(name) == nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: fmt.Sprintf("node %s not found", name)})
+		return
+	}
+
+	labels := LabelRequest{}
+	err := json.NewDecoder(req.Body).Decode(&labels)
+	var n *node.Node
+	if err == nil {
+		n, err = a.Manager.LabelNode(name, labels.Set, labels.Remove)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error labeling node %s: %v\n", name, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(n)
+}
+
+// TaintRequest is the body of a request to change the taints of a node.
+// Taints in Remove match by key, and by effect if it is set.
+type TaintRequest struct {
+	Add    []node.Taint
+	Remove []node.Taint
+}
+
+func (a *Api) TaintNodeHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	name := chi.URLParam(req, "nodeName")
+	if a.Manager.getNode(name) == nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: fmt.Sprintf("node %s not found", name)})
+		return
+	}
+
+	taints := TaintRequest{}
+	err := json.NewDecoder(req.Body).Decode(&taints)
+	var n *node.Node
+	if err == nil {
+		n, err = a.Manager.TaintNode(name, taints.Add, taints.Remove)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error tainting node %s: %v\n", name, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(n)
+}
+
+// nodeAction runs a manager action on the node named in the request and
+// responds with the node.
+func (a *Api) nodeAction(res http.ResponseWriter, req *http.Request, action func(string) (*node.Node, error)) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	n, err := action(chi.URLParam(req, "nodeName"))
+	if err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(n)
+}
+
+func (a *Api) CreateCronTaskHandler(res http.ResponseWriter, req *http.Request) {
+	data := json.NewDecoder(req.Body)
+	data.DisallowUnknownFields()
+
+	cron := task.CronTask{}
+	err := data.Decode(&cron)
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	if err == nil {
+		err = a.Manager.AddCronTask(&cron)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error creating cron task: %v\n", err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	log.Printf("Added cron task %s (%v)\n", cron.Name, cron.ID)
+	res.WriteHeader(201)
+	json.NewEncoder(res).Encode(cron)
+}
+
+func (a *Api) GetCronTasksHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(a.Manager.GetCronTasks())
+}
+
+func (a *Api) DeleteCronTaskHandler(res http.ResponseWriter, req *http.Request) {
+	cronID, _ := uuid.Parse(chi.URLParam(req, "cronId"))
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	err := a.Manager.DeleteCronTask(cronID.String())
+	if err != nil {
+		log.Printf("No cron task with ID %v found\n", cronID)
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	log.Printf("Deleted cron task %v\n", cronID)
+	res.WriteHeader(204)
+}
+
+func (a *Api) CreateServiceHandler(res http.ResponseWriter, req *http.Request) {
+	data := json.NewDecoder(req.Body)
+	data.DisallowUnknownFields()
+
+	service := task.Service{}
+	err := data.Decode(&service)
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	if err == nil {
+		err = a.Manager.AddService(&service)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error creating service: %v\n", err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	log.Printf("Added service %s (%v)\n", service.Name, service.ID)
+	res.WriteHeader(201)
+	json.NewEncoder(res).Encode(service)
+}
+
+func (a *Api) GetServicesHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(a.Manager.GetServices())
+}
+
+func (a *Api) GetServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	service, err := a.Manager.GetService(chi.URLParam(req, "service"))
+	if err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(service)
+}
+
+// ScaleRequest is the body of a request to scale a service.
+type ScaleRequest struct {
+	Replicas int
+}
+
+func (a *Api) ScaleServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	key := chi.URLParam(req, "service")
+	if _, err := a.Manager.GetService(key); err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	scale := ScaleRequest{}
+	err := json.NewDecoder(req.Body).Decode(&scale)
+	var service *task.Service
+	if err == nil {
+		service, err = a.Manager.ScaleService(key, scale.Replicas)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error scaling service %s: %v\n", key, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(service)
+}
+
+// ServiceUpdate is the body of a request to update a service. Replicas is
+// optional, the service keeps its number of replicas when it is left out.
+type ServiceUpdate struct {
+	task.Service
+	Replicas *int
+}
+
+func (a *Api) UpdateServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	key := chi.URLParam(req, "service")
+	if _, err := a.Manager.GetService(key); err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	data := json.NewDecoder(req.Body)
+	data.DisallowUnknownFields()
+
+	spec := ServiceUpdate{}
+	err := data.Decode(&spec)
+	var service *task.Service
+	if err == nil {
+		service, err = a.Manager.UpdateService(key, &spec.Service, spec.Replicas)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error updating service %s: %v\n", key, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(service)
+}
+
+// RollbackRequest is the body of a request to roll a service back. A zero
+// Revision means the previous one.
+type RollbackRequest struct {
+	Revision int
+}
+
+func (a *Api) RollbackServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	key := chi.URLParam(req, "service")
+	if _, err := a.Manager.GetService(key); err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	rollback := RollbackRequest{}
+	err := json.NewDecoder(req.Body).Decode(&rollback)
+	var service *task.Service
+	if err == nil || err == io.EOF {
+		service, err = a.Manager.RollbackService(key, rollback.Revision)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error rolling back service %s: %v\n", key, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(service)
+}
+
+func (a *Api) ResumeServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	key := chi.URLParam(req, "service")
+	if _, err := a.Manager.GetService(key); err != nil {
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	service, err := a.Manager.ResumeService(key)
+	if err != nil {
+		msg := fmt.Sprintf("Error resuming service %s: %v\n", key, err)
+		log.Print(msg)
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(200)
+	json.NewEncoder(res).Encode(service)
+}
+
+func (a *Api) DeleteServiceHandler(res http.ResponseWriter, req *http.Request) {
+	a.Manager.mu.Lock()
+	defer a.Manager.mu.Unlock()
+	key := chi.URLParam(req, "service")
+	err := a.Manager.DeleteService(key)
+	if err != nil {
+		log.Printf("No service %s found\n", key)
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return
+	}
+
+	log.Printf("Deleted service %s\n", key)
+	res.WriteHeader(204)
+}
+
+// GetTaskLogsHandler proxies the logs of a task from the worker it runs on.
+func (a *Api) GetTaskLogsHandler(res http.ResponseWriter, req *http.Request) {
+	tID, worker, ok := a.workerForTask(res, req)
+	if !ok {
+		return
+	}
+	a.proxyToWorker(res, req, worker, fmt.Sprintf("/tasks/%s/logs", tID))
+}
+
+// ExecTaskHandler proxies an exec session to the worker running the task.
+// The connection to the worker is upgraded first, then the client's
+// connection is switched over and the two are spliced together.
+func (a *Api) ExecTaskHandler(res http.ResponseWriter, req *http.Request) {
+	tID, worker, ok := a.workerForTask(res, req)
+	if !ok {
+		return
+	}
+
+	hj, ok := res.(http.Hijacker)
+	if !ok || req.Header.Get("Upgrade") != utils.UpgradeProtocol {
+		res.WriteHeader(400)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 400, Message: fmt.Sprintf("exec requires an upgrade to %s", utils.UpgradeProtocol)})
+		return
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/exec", worker, tID)
+	workerReq, err := http.NewRequest("POST", url, req.Body)
+	if err != nil {
+		res.WriteHeader(500)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 500, Message: err.Error()})
+		return
+	}
+	workerReq.ContentLength = req.ContentLength
+	workerReq.Header.Set("Content-Type", "application/json")
+
+	backend, resp, err := utils.DialUpgrade(worker, workerReq)
+	if err != nil {
+		log.Printf("Error starting exec for task %v on %s: %v\n", tID, worker, err)
+		status := 502
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		res.WriteHeader(status)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: status, Message: err.Error()})
+		return
+	}
+	defer backend.Close()
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Error hijacking connection for exec on task %v: %v\n", tID, err)
+		return
+	}
+	defer conn.Close()
+
+	headers := http.Header{}
+	headers.Set(utils.ExecIDHeader, resp.Header.Get(utils.ExecIDHeader))
+	headers.Set(utils.ExecTtyHeader, resp.Header.Get(utils.ExecTtyHeader))
+	err = utils.WriteSwitchingProtocols(conn, headers)
+	if err != nil {
+		return
+	}
+
+	utils.Splice(&utils.UpgradedConn{Conn: conn, Reader: brw.Reader}, backend)
+}
+
+func (a *Api) InspectExecHandler(res http.ResponseWriter, req *http.Request) {
+	tID, worker, ok := a.workerForTask(res, req)
+	if !ok {
+		return
+	}
+	a.proxyToWorker(res, req, worker, fmt.Sprintf("/tasks/%s/exec/%s", tID, chi.URLParam(req, "execId")))
+}
+
+func (a *Api) ResizeExecHandler(res http.ResponseWriter, req *http.Request) {
+	tID, worker, ok := a.workerForTask(res, req)
+	if !ok {
+		return
+	}
+	a.proxyToWorker(res, req, worker, fmt.Sprintf("/tasks/%s/exec/%s/resize", tID, chi.URLParam(req, "execId")))
+}
+
+// workerForTask resolves the worker running the task named by the taskId
+// URL parameter, writing a 404 response if it isn't known.
+func (a *Api) workerForTask(res http.ResponseWriter, req *http.Request) (uuid.UUID, string, bool) {
+	tID, _ := uuid.Parse(chi.URLParam(req, "taskId"))
+	a.Manager.mu.Lock()
+	worker, ok := a.Manager.TaskWorkerMap[tID]
+	a.Manager.mu.Unlock()
+	if !ok {
+		msg := fmt.Sprintf("No worker found for task %v", tID)
+		log.Println(msg)
+		res.WriteHeader(404)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 404, Message: msg})
+		return tID, "", false
+	}
+	return tID, worker, true
+}
+
+// proxyToWorker forwards req, including its query, to path on the worker
+// and streams the response back.
+func (a *Api) proxyToWorker(res http.ResponseWriter, req *http.Request, worker string, path string) {
+	url := fmt.Sprintf("http://%s%s?%s", worker, path, req.URL.RawQuery)
+	proxyReq, err := http.NewRequestWithContext(req.Context(), req.Method, url, req.Body)
+	if err != nil {
+		res.WriteHeader(500)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 500, Message: err.Error()})
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(proxyReq)
+	if err != nil {
+		msg := fmt.Sprintf("Error connecting to worker %s: %v", worker, err)
+		log.Println(msg)
+		res.WriteHeader(502)
+		json.NewEncoder(res).Encode(ErrResponse{HTTPStatusCode: 502, Message: msg})
+		return
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		res.Header().Set("Content-Type", ct)
+	}
+	res.WriteHeader(resp.StatusCode)
+	flushCopy(res, resp.Body)
+}
+
+// flushCopy copies src to res, flushing after every read so that streamed
+// responses aren't held back by buffering.
+func flushCopy(res http.ResponseWriter, src io.Reader) error {
+	f, _ := res.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := res.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if f != nil {
+				f.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
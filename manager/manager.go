@@ -13,17 +13,25 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-connections/nat"
-	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 )
 
 type Manager struct {
-    Pending       queue.Queue
+    // mu guards the state of the manager: its stores, queue, workers, nodes
+    // and the maps between tasks and workers. The API handlers and the
+    // background loops hold it while they use that state. Workers, health
+    // checks and extenders are called without it: what the call needs is
+    // copied under the lock, and its result applied under the lock again.
+    mu            sync.Mutex
+    Pending       *PriorityQueue
     TaskDb        store.Store
     EventDb       store.Store
+    CronDb        store.Store
+    ServiceDb     store.Store
     Workers       []string
     WorkerTaskMap map[string][]uuid.UUID
     TaskWorkerMap map[uuid.UUID]string
@@ -31,9 +39,39 @@ type Manager struct {
 
 		WorkerNodes   []*node.Node
     Scheduler     scheduler.Scheduler
+    // NodeGracePeriod is how long a worker can fail before its tasks are
+    // moved to other workers
+    NodeGracePeriod time.Duration
+    // LostTasks maps the tasks moved off a failed worker to that worker
+    LostTasks     map[uuid.UUID]string
+    // Moving maps the tasks being moved off a drained node to that node
+    Moving        map[uuid.UUID]string
+    // Preemption lets tasks that fit nowhere evict tasks with a lower
+    // priority to make room
+    Preemption    bool
+    // health holds the result of the latest health check of running tasks,
+    // made without the lock, for taskReady
+    health        map[uuid.UUID]error
+    // stops are the copies of tasks to stop on workers, sent by sendStops
+    // once the lock is released
+    stops         []taskStop
 }
 
+// taskStop is a copy of a task to stop on a worker.
+type taskStop struct {
+	worker string
+	id     uuid.UUID
+}
+
+
 
+// DefaultNodeGracePeriod is how long a worker can fail before its tasks are
+// rescheduled, unless the manager is configured otherwise.
+const DefaultNodeGracePeriod = time.Minute
+
+// unschedulableBackoff is how long a task that fits on no node waits before
+// it is tried again.
+const unschedulableBackoff = 30 * time.Second
 
 func New(workers []string , schedulerType string, dbType string) *Manager {
 	workerTaskMap := make(map[string][]uuid.UUID)
@@ -48,33 +86,41 @@ func New(workers []string , schedulerType string, dbType string) *Manager {
 		nodes = append(nodes, n)
 	}
 
-	var s scheduler.Scheduler
-	switch schedulerType {
-	case "roundrobin":
-		s = &scheduler.RoundRobin{Name: "roundrobin"}
-	case "greedy":
-		s = &scheduler.Greedy{Name: "roundrobin"}
-	default:
-		s = &scheduler.RoundRobin{Name: "roundrobin"}
+	s, schedulerErr := scheduler.New(schedulerType)
+	if schedulerErr != nil {
+		log.Printf("%v, using roundrobin\n", schedulerErr)
+		s, _ = scheduler.New("roundrobin")
 	}
 	m := Manager{
-		Pending:       *queue.New(),
+		Pending:       NewPriorityQueue(),
 		Workers:       workers,
 		WorkerTaskMap: workerTaskMap,
 		TaskWorkerMap: taskWorkerMap,
 		WorkerNodes:   nodes,
 		Scheduler:     s,
+		NodeGracePeriod: DefaultNodeGracePeriod,
+		LostTasks:     make(map[uuid.UUID]string),
+		Moving:        make(map[uuid.UUID]string),
+		health:        make(map[uuid.UUID]error),
 	}
 	var ts store.Store
 	var es store.Store
+	var cs store.Store
+	var ss store.Store
 	var err error
+	var cronErr error
+	var serviceErr error
 	switch dbType {
 	case "memory":
 		ts = store.NewInMemoryTaskStore()
 		es = store.NewInMemoryTaskEventStore()
+		cs = store.NewInMemoryCronTaskStore()
+		ss = store.NewInMemoryServiceStore()
 	case "persistent":
 		ts, err = store.NewTaskStore("tasks.db", 0600, "tasks")
 		es, err = store.NewEventStore("events.db", 0600, "events")
+		cs, cronErr = store.NewCronTaskStore("crons.db", 0600, "crons")
+		ss, serviceErr = store.NewServiceStore("services.db", 0600, "services")
 	}
 
 	if err != nil {
@@ -85,8 +131,18 @@ func New(workers []string , schedulerType string, dbType string) *Manager {
 		log.Fatalf("unable to create task event store: %v", err)
 	}
 
+	if cronErr != nil {
+		log.Fatalf("unable to create cron task store: %v", cronErr)
+	}
+
+	if serviceErr != nil {
+		log.Fatalf("unable to create service store: %v", serviceErr)
+	}
+
 	m.TaskDb = ts
 	m.EventDb = es
+	m.CronDb = cs
+	m.ServiceDb = ss
 	return &m
 }
 
@@ -102,58 +158,198 @@ func (m *Manager) GetTasks() []*task.Task{
 }
 
 
-func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
-	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
-	if candidates == nil {
-			msg := fmt.Sprintf("No available candidates match resource request for task %v", t.ID)
-			err := errors.New(msg)
-			return nil, err
+// SelectWorker runs the scheduler s for the task against nodes. It is called
+// without the manager lock, on a snapshot of the nodes, as the scheduler may
+// call an extender.
+func SelectWorker(s scheduler.Scheduler, t task.Task, nodes []*node.Node) (*node.Node, error) {
+	candidates := s.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		var rejected map[string]string
+		if e, ok := s.(*scheduler.Extender); ok {
+			rejected = e.Failed
+		}
+		return nil, errors.New(unschedulableReason(t, nodes, rejected))
+	}
+	scores := s.Score(t, candidates)
+	selectedNode := s.Pick(scores, candidates)
+	if selectedNode == nil {
+		return nil, fmt.Errorf("no candidate could be scored for task %v", t.ID)
 	}
-	scores := m.Scheduler.Score(t, candidates)
-	selectedNode := m.Scheduler.Pick(scores, candidates)
 
 	return selectedNode, nil
 }
 
+// schedulingNodes brings the nodes' allocations up to date and returns a
+// snapshot of the ones tasks can be placed on, for the scheduler to read
+// without the lock. SendWork and Simulate both start from it so that a dry
+// run sees the nodes the way a real placement would.
+func (m *Manager) schedulingNodes() []*node.Node {
+	m.updateNodeAllocations()
+	var nodes []*node.Node
+	for _, n := range m.readyNodes() {
+		c := *n
+		c.Labels = make(map[string]string)
+		for k, v := range n.Labels {
+			c.Labels[k] = v
+		}
+		c.Tasks = nil
+		for _, t := range n.Tasks {
+			placed := *t
+			c.Tasks = append(c.Tasks, &placed)
+		}
+		nodes = append(nodes, &c)
+	}
+	return nodes
+}
+
+// unschedulableReason explains why none of the nodes can take the task, e.g.
+// "unschedulable: insufficient memory on all nodes". rejected holds the
+// reasons a scheduler extender gave for the nodes it filtered out.
+func unschedulableReason(t task.Task, nodes []*node.Node, rejected map[string]string) string {
+	if len(nodes) == 0 {
+		return "unschedulable: no ready nodes"
+	}
+
+	var reasons []string
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		reason := filterReason(t, n, nodes, rejected)
+		if reason == "" {
+			continue
+		}
+		if counts[reason] == 0 {
+			reasons = append(reasons, reason)
+		}
+		counts[reason]++
+	}
+	if len(reasons) == 1 && counts[reasons[0]] == len(nodes) {
+		return fmt.Sprintf("unschedulable: %s on all nodes", reasons[0])
+	}
+
+	var parts []string
+	for _, reason := range reasons {
+		noun := "nodes"
+		if counts[reason] == 1 {
+			noun = "node"
+		}
+		parts = append(parts, fmt.Sprintf("%s on %d %s", reason, counts[reason], noun))
+	}
+	if len(parts) == 0 {
+		return "unschedulable: no node matches the task"
+	}
+	return "unschedulable: " + strings.Join(parts, ", ")
+}
+
+// filterReason returns why the task can't be placed on the node, from the
+// built-in checks or the reasons an extender rejected nodes for.
+func filterReason(t task.Task, n *node.Node, nodes []*node.Node, rejected map[string]string) string {
+	reason := scheduler.CheckNode(t, n, nodes)
+	if reason == "" && rejected[n.Name] != "" {
+		reason = "extender: " + rejected[n.Name]
+	}
+	return reason
+}
+
+// updateNodeAllocations recomputes the tasks placed on each node from
+// WorkerTaskMap, along with the CPU, memory, disk and host ports they
+// allocate and their count. Only tasks currently scheduled or running on the
+// node count.
+func (m *Manager) updateNodeAllocations() {
+	tasks := make(map[uuid.UUID]*task.Task)
+	for _, t := range m.GetTasks() {
+		if t.State == task.Scheduled || t.State == task.Running {
+			tasks[t.ID] = t
+		}
+	}
+
+	for _, n := range m.WorkerNodes {
+		var placed []*task.Task
+		for _, id := range m.WorkerTaskMap[n.Name] {
+			t, ok := tasks[id]
+			if !ok || m.TaskWorkerMap[id] != n.Name {
+				continue
+			}
+			// a task is only counted once, WorkerTaskMap can list it again
+			// after it was restarted
+			delete(tasks, id)
+			placed = append(placed, t)
+		}
+		n.SetTasks(placed)
+	}
+}
+
 func (m *Manager) AddTask(taskEvent task.TaskEvent){
 	m.Pending.Enqueue(taskEvent)
 }
 
 func (m *Manager) updateTasks(){
-	for _, worker := range m.Workers {
-		url := fmt.Sprintf("http://%s/tasks",worker);
-		res,err := http.Get(url)
-		if err != nil {
-			log.Printf("Error connecting to %v: %v\n", worker, err)
-		}
+	m.mu.Lock()
+	workers := append([]string(nil), m.Workers...)
+	m.mu.Unlock()
 
-		decoder := json.NewDecoder(res.Body);
+	// a worker that stops responding mustn't hold up the others
+	client := http.Client{Timeout: 10 * time.Second}
+	for _, worker := range workers {
+		url := fmt.Sprintf("http://%s/tasks",worker);
+		res,err := client.Get(url)
 		var tasks []*task.Task
-		err = decoder.Decode(&tasks)
-		if err != nil {
-			log.Printf("Error unmarshaling task: %s\n",err)
+		if err == nil {
+			decoder := json.NewDecoder(res.Body);
+			if decodeErr := decoder.Decode(&tasks); decodeErr != nil {
+				log.Printf("Error unmarshaling task: %s\n",decodeErr)
+			}
+			res.Body.Close()
 		}
 
-		for _,t := range tasks {
-			log.Printf("Attempting to update task %v\n", t.ID)
+		m.mu.Lock()
+		m.updateWorkerTasks(worker, tasks, err)
+		m.mu.Unlock()
+	}
+	// copies of moved tasks the workers still run
+	m.sendStops()
+}
 
-			result, err := m.TaskDb.Get(t.ID.String())
+// updateWorkerTasks records the state of the tasks a worker reported, or
+// that the worker couldn't be reached.
+func (m *Manager) updateWorkerTasks(worker string, tasks []*task.Task, err error) {
+	n := m.getNode(worker)
+	if err != nil {
+		log.Printf("Error connecting to %v: %v\n", worker, err)
+		if n != nil && n.LastHeartbeat.IsZero() {
+			m.setNodeStatus(n, node.NotReady)
+		}
+		return
+	}
+	if n != nil && n.LastHeartbeat.IsZero() {
+		m.setNodeStatus(n, node.Ready)
+	}
 
-			if err != nil {
-				log.Printf("Task with id %s not found\n",t.ID)
-				return
-			}
-			taskPersisted := result.(*task.Task)
+	for _,t := range tasks {
+		log.Printf("Attempting to update task %v\n", t.ID)
 
-			taskPersisted.State = t.State
-			taskPersisted.StartTime = t.StartTime
-			taskPersisted.FinishTime = t.FinishTime
-			taskPersisted.ContainerID = t.ContainerID
-			taskPersisted.HostPorts = t.HostPorts
+		if m.suppressDuplicate(worker, t) {
+			continue
+		}
 
-			m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
+		result, err := m.TaskDb.Get(t.ID.String())
 
+		if err != nil {
+			log.Printf("Task with id %s not found\n",t.ID)
+			continue
 		}
+		taskPersisted := result.(*task.Task)
+
+		taskPersisted.State = t.State
+		taskPersisted.StartTime = t.StartTime
+		taskPersisted.FinishTime = t.FinishTime
+		taskPersisted.ContainerID = t.ContainerID
+		taskPersisted.HostPorts = t.HostPorts
+		taskPersisted.ExitCode = t.ExitCode
+		taskPersisted.OOMKilled = t.OOMKilled
+		taskPersisted.Error = t.Error
+
+		m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
+
 	}
 }
 
@@ -173,90 +369,191 @@ func (m *Manager) ProcessTasks() {
 }
 
 func (m *Manager) SendWork(){
-	if m.Pending.Len() > 0 {
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
-		m.EventDb.Put(te.ID.String(),te)
-		log.Printf("Pulled %v off pending queue", te)
-
-		taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
-		if ok {
-			result,err := m.TaskDb.Get(te.Task.ID.String())
-			if err != nil {
-        log.Printf("unable to schedule task: %s", err)
-        return
-			}
-			persistedTask, ok := result.(*task.Task)
-			if !ok {
-        log.Printf("unable to convert task to task.Task type")
-        return
-			}
+	// evictions made room for the task while the manager was locked
+	defer m.sendStops()
 
-			if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, te.State) {
-				m.stopTask(taskWorker, te.Task.ID.String())
-				return
-			}
+	m.mu.Lock()
+	te, w, ok := m.nextWork()
+	var s scheduler.Scheduler
+	var nodes []*node.Node
+	if ok && w == "" {
+		s = scheduler.Copy(m.Scheduler)
+		nodes = m.schedulingNodes()
+	}
+	preemption := m.Preemption
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
 
-			log.Printf("invalid request: existing task %s is in state %v and cannot transition to the completed state", persistedTask.ID.String(), persistedTask.State)
+	if w == "" {
+		p := place(s, te.Task, nodes, preemption)
+		m.mu.Lock()
+		// SendWork is the only user of the scheduler's state, such as the
+		// round robin cursor
+		m.Scheduler = s
+		w, ok = m.assignWork(te, p)
+		m.mu.Unlock()
+		if !ok {
 			return
 		}
+	}
+
+	if te.State == task.Completed {
+		m.stopTask(w, te.Task.ID.String())
+		return
+	}
+
+	t := te.Task
+	data, err := json.Marshal(te)
+	if err != nil {
+		log.Printf("Unable to marshal task object: %v.", t)
+	}
+
+	url := fmt.Sprintf("http://%s/tasks", w)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		log.Printf("[manager] Error connecting to %v: %v", w, err)
+		m.mu.Lock()
+		m.unassignTask(t.ID)
+		m.Pending.Enqueue(te)
+		m.mu.Unlock()
+		return
+	}
 
-		t := te.Task
-		w, err := m.SelectWorker(t)
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrResponse{}
+		err := d.Decode(&e)
 		if err != nil {
-			log.Printf("error selecting worker for task %s: %v", t.ID, err)
+			fmt.Printf("Error decoding response: %s\n", err.Error())
 			return
 		}
+		log.Printf("Response error (%d): %s", e.HTTPStatusCode, e.Message)
+		return
+	}
 
-		log.Printf("[manager] selected worker %s for task %s", w.Name, t.ID)
-
-		m.WorkerTaskMap[w.Name] = append(m.WorkerTaskMap[w.Name], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w.Name
+	t = task.Task{}
+	err = d.Decode(&t)
+	if err != nil {
+		fmt.Printf("Error decoding response: %s\n", err.Error())
+		return
+	}
+	log.Printf("[manager] received response from worker: %#v\n", t)
+}
 
-		t.State = task.Scheduled
-		m.TaskDb.Put(t.ID.String(),&t)
+// nextWork pulls the next event off the pending queue and returns it with
+// the worker to send it to. A Completed event goes to the worker running the
+// task, while a task that is new to the manager is returned without a worker
+// for SendWork to place. It returns false if there is nothing to send.
+func (m *Manager) nextWork() (task.TaskEvent, string, bool) {
+	te, ok := m.Pending.Dequeue()
+	if !ok {
+		log.Printf("No tasks in the queue \n")
+		return te, "", false
+	}
+	m.EventDb.Put(te.ID.String(),&te)
+	log.Printf("Pulled %v off pending queue", te)
 
-		data, err := json.Marshal(te)
+	taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
+	if ok {
+		result,err := m.TaskDb.Get(te.Task.ID.String())
 		if err != nil {
-			log.Printf("Unable to marshal task object: %v.", t)
+			log.Printf("unable to schedule task: %s", err)
+			return te, "", false
+		}
+		persistedTask, ok := result.(*task.Task)
+		if !ok {
+			log.Printf("unable to convert task to task.Task type")
+			return te, "", false
 		}
 
-		url := fmt.Sprintf("http://%s/tasks", w.Name)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
-		if err != nil {
-			log.Printf("[manager] Error connecting to %v: %v", w, err)
-			m.Pending.Enqueue(t)
-			return
+		if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, te.State) {
+			return te, taskWorker, true
 		}
 
-		d := json.NewDecoder(resp.Body)
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrResponse{}
-			err := d.Decode(&e)
-			if err != nil {
-				fmt.Printf("Error decoding response: %s\n", err.Error())
-				return
+		log.Printf("invalid request: existing task %s is in state %v and cannot transition to the completed state", persistedTask.ID.String(), persistedTask.State)
+		return te, "", false
+	}
+	return te, "", true
+}
+
+// placement is the worker the scheduler picked for a task, or why it picked
+// none and the tasks to preempt on a node to make room.
+type placement struct {
+	worker  string
+	err     error
+	preempt string
+	victims []uuid.UUID
+}
+
+// place runs the scheduler for a task against a snapshot of the nodes,
+// without the manager lock.
+func place(s scheduler.Scheduler, t task.Task, nodes []*node.Node, preemption bool) placement {
+	n, err := SelectWorker(s, t, nodes)
+	if err == nil {
+		return placement{worker: n.Name}
+	}
+
+	p := placement{err: err}
+	if preemption {
+		n, victims := scheduler.Preempt(s, t, nodes)
+		if n != nil {
+			p.preempt = n.Name
+			for _, v := range victims {
+				p.victims = append(p.victims, v.ID)
 			}
-			log.Printf("Response error (%d): %s", e.HTTPStatusCode, e.Message)
-			return
 		}
+	}
+	return p
+}
 
-		t = task.Task{}
-		err = d.Decode(&t)
-		if err != nil {
-			fmt.Printf("Error decoding response: %s\n", err.Error())
-			return
+// assignWork records the worker a new task was placed on and stores the task
+// as Scheduled. A task that couldn't be placed is held back, or preempts
+// lower priority tasks. It returns the worker to send the task to, and false
+// if there is none.
+func (m *Manager) assignWork(te task.TaskEvent, p placement) (string, bool) {
+	t := te.Task
+	if err := p.err; err != nil {
+		log.Printf("error selecting worker for task %s: %v", t.ID, err)
+		if from, ok := m.Moving[t.ID]; ok {
+			// the task is still running on the drained node, it stays
+			// there instead of waiting for room elsewhere
+			if n := m.getNode(from); n != nil {
+				m.cancelMove(n, t.ID, fmt.Sprintf("task %s can't be moved: %v", t.ID, err))
+				return "", false
+			}
 		}
-		w.TaskCount++
-		log.Printf("[manager] received response from worker: %#v\n", t)
-	}else{
-		log.Printf("No tasks in the queue \n")
+		// leave the task pending until a worker can take it, with the
+		// reason it can't be placed yet
+		pending := t
+		pending.State = task.Pending
+		pending.Error = err.Error()
+		m.TaskDb.Put(pending.ID.String(), &pending)
+		if m.preempt(t, p) {
+			// the task is placed once the evicted tasks make room
+			m.Pending.Enqueue(te)
+			return "", false
+		}
+		// hold the task back so that the tasks queued behind it get
+		// their turn
+		m.Pending.Requeue(te, unschedulableBackoff)
+		return "", false
 	}
+
+	log.Printf("[manager] selected worker %s for task %s", p.worker, t.ID)
+
+	m.WorkerTaskMap[p.worker] = append(m.WorkerTaskMap[p.worker], te.Task.ID)
+	m.TaskWorkerMap[t.ID] = p.worker
+
+	t.State = task.Scheduled
+	m.TaskDb.Put(t.ID.String(),&t)
+	return p.worker, true
 }
 
 
 func (m *Manager) stopTask(worker string, taskID string) {
-	client := &http.Client{}
+	client := &http.Client{Timeout: 10 * time.Second}
 	url := fmt.Sprintf("http://%s/tasks/%s", worker, taskID)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -278,20 +575,73 @@ func (m *Manager) stopTask(worker string, taskID string) {
 	log.Printf("task %s has been scheduled to be stopped", taskID)
 }
 
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	log.Printf("Calling health check for task %s: %s\n", t.ID, t.HealthCheck)
+// stopLater queues a copy of a task to be stopped on a worker once the
+// manager lock is released.
+func (m *Manager) stopLater(worker string, id uuid.UUID) {
+	m.stops = append(m.stops, taskStop{worker: worker, id: id})
+}
 
-	w := m.TaskWorkerMap[t.ID]
+// sendStops sends the stops queued by stopLater. It is called without the
+// manager lock.
+func (m *Manager) sendStops() {
+	m.mu.Lock()
+	stops := m.stops
+	m.stops = nil
+	m.mu.Unlock()
+
+	for _, s := range stops {
+		m.stopTask(s.worker, s.id.String())
+	}
+}
+
+// requestStop asks for a running task to be stopped. Tasks that haven't
+// started yet can't be stopped and false is returned for them.
+func (m *Manager) requestStop(id uuid.UUID) bool {
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		return false
+	}
+	t := *result.(*task.Task)
+	if t.State != task.Running {
+		return false
+	}
+
+	t.State = task.Completed
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+	})
+	return true
+}
+
+// hasHealthCheck reports whether the health of a task can be checked: it
+// has a health check and publishes a port to reach it on. Tasks that can't
+// be checked count as healthy.
+func hasHealthCheck(t *task.Task) bool {
+	return t.HealthCheck != "" && getHostPort(t.HostPorts) != nil
+}
+
+// checkTaskHealth calls the health check of a task running on worker. It is
+// called without the manager lock.
+func checkTaskHealth(t task.Task, w string) error {
+	if !hasHealthCheck(&t) {
+		log.Printf("Task %s has no health check or published port, skipping\n", t.ID)
+		return nil
+	}
 	hostPort := getHostPort(t.HostPorts)
 	worker := strings.Split(w, ":")
 	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
 	log.Printf("Calling health check for task %s: %s\n", t.ID, url)
-	resp, err := http.Get(url)
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
 	if err != nil {
 			msg := fmt.Sprintf("Error connecting to health check %s", url)
 			log.Println(msg)
 			return errors.New(msg)
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 			msg := fmt.Sprintf("Error health check for task %s did not return 200\n", t.ID)
@@ -304,6 +654,44 @@ func (m *Manager) checkTaskHealth(t task.Task) error {
 	return nil
 }
 
+// healthProbe is a health check of a task to make without the manager lock.
+type healthProbe struct {
+	task   task.Task
+	worker string
+}
+
+// healthProbeOf returns the probe of a running task that has a health check.
+func (m *Manager) healthProbeOf(t *task.Task) (healthProbe, bool) {
+	w, ok := m.TaskWorkerMap[t.ID]
+	if !ok || t.State != task.Running || !hasHealthCheck(t) {
+		return healthProbe{}, false
+	}
+	return healthProbe{task: *t, worker: w}, true
+}
+
+// probeHealth makes the health checks without the manager lock, then records
+// their results for taskReady and returns them. Results of tasks that are no
+// longer running are dropped.
+func (m *Manager) probeHealth(probes []healthProbe) map[uuid.UUID]error {
+	results := make(map[uuid.UUID]error)
+	for _, p := range probes {
+		results[p.task.ID] = checkTaskHealth(p.task, p.worker)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, err := range results {
+		m.health[id] = err
+	}
+	for id := range m.health {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil || result.(*task.Task).State != task.Running {
+			delete(m.health, id)
+		}
+	}
+	return results
+}
+
 func (m *Manager) DoHealthChecks() {
 	for {
 			m.doHealthChecks()
@@ -311,22 +699,75 @@ func (m *Manager) DoHealthChecks() {
 	}
 }
 
-
+// doHealthChecks restarts failed tasks and checks the health of running
+// ones. The checks are made without the manager lock, and a task that fails
+// its check is only restarted if it hasn't changed in the meantime.
 func (m *Manager) doHealthChecks() {
 	m.updateTasks()
+
+	m.mu.Lock()
+	var probes []healthProbe
+	var restarts []restart
 	for _, t := range m.GetTasks() {
+			if t.IsJob() && t.State != task.Failed {
+					continue
+			}
+			if t.ServiceID != uuid.Nil {
+					// the service reconciler replaces failed and unhealthy replicas
+					if !m.inRollout(t) {
+						if p, ok := m.healthProbeOf(t); ok {
+							probes = append(probes, p)
+						}
+					}
+					continue
+			}
 			if t.State == task.Running && t.RestartCount < 3 {
-					err := m.checkTaskHealth(*t)
-					if err != nil {
-						m.restartTask(t)
+					if p, ok := m.healthProbeOf(t); ok {
+						probes = append(probes, p)
 					}
 			} else if t.State == task.Failed && t.RestartCount < 3 {
-					m.restartTask(t)
+					restarts = append(restarts, m.restartTask(t))
+			}
+	}
+	m.mu.Unlock()
+
+	results := m.probeHealth(probes)
+
+	m.mu.Lock()
+	for _, p := range probes {
+			if results[p.task.ID] == nil {
+					continue
+			}
+			result, err := m.TaskDb.Get(p.task.ID.String())
+			if err != nil {
+					continue
+			}
+			t := result.(*task.Task)
+			if t.State != task.Running || t.ContainerID != p.task.ContainerID {
+					continue
+			}
+			if t.ServiceID != uuid.Nil {
+					m.requestStop(t.ID)
+			} else {
+					restarts = append(restarts, m.restartTask(t))
 			}
 	}
+	m.mu.Unlock()
+
+	for _, r := range restarts {
+			m.sendRestart(r)
+	}
+}
+
+// restart is a task to send to its worker again to restart it.
+type restart struct {
+	worker string
+	event  task.TaskEvent
 }
 
-func (m *Manager) restartTask(t *task.Task) {
+// restartTask stores a task as Scheduled again and returns the restart to
+// send to its worker with sendRestart, once the manager lock is released.
+func (m *Manager) restartTask(t *task.Task) restart {
 	w := m.TaskWorkerMap[t.ID]
 	t.State = task.Scheduled
 	t.RestartCount++
@@ -338,17 +779,25 @@ func (m *Manager) restartTask(t *task.Task) {
 			Timestamp: time.Now(),
 			Task:      *t,
 	}
+	return restart{worker: w, event: te}
+}
+
+// sendRestart sends a task restarted by restartTask to its worker.
+func (m *Manager) sendRestart(r restart) {
+	te := r.event
+	t := te.Task
 	data, err := json.Marshal(te)
 	if err != nil {
 			log.Printf("Unable to marshal task object: %v.", t)
 			return
 	}
 
-	url := fmt.Sprintf("http://%s/tasks", w)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	url := fmt.Sprintf("http://%s/tasks", r.worker)
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
-			log.Printf("Error connecting to %v: %v", w, err)
-			m.Pending.Enqueue(t)
+			log.Printf("Error connecting to %v: %v", r.worker, err)
+			m.Pending.Enqueue(te)
 			return
 	}
 
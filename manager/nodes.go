@@ -0,0 +1,311 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"cube/node"
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// A worker is NotReady when it hasn't sent a heartbeat for notReadyTimeout
+// and Lost after lostTimeout.
+const (
+	notReadyTimeout = 30 * time.Second
+	lostTimeout     = 2 * time.Minute
+)
+
+// RegisterWorker adds a worker to the cluster, or updates it if it is already
+// known, and marks it Ready.
+func (m *Manager) RegisterWorker(r node.Registration) (*node.Node, error) {
+	if r.Address == "" {
+		return nil, fmt.Errorf("worker address is required")
+	}
+
+	n := m.getNode(r.Address)
+	if n == nil {
+		n = node.NewNode(r.Address, fmt.Sprintf("http://%v", r.Address), "worker")
+		m.WorkerNodes = append(m.WorkerNodes, n)
+		if _, ok := m.WorkerTaskMap[r.Address]; !ok {
+			m.WorkerTaskMap[r.Address] = []uuid.UUID{}
+		}
+		log.Printf("registered worker %s at %s\n", r.Name, r.Address)
+	}
+	if !m.isWorker(r.Address) {
+		m.Workers = append(m.Workers, r.Address)
+	}
+
+	n.WorkerName = r.Name
+	n.Cpu = r.Cpu
+	n.Memory = r.Memory
+	n.Disk = r.Disk
+	n.Labels = r.Labels
+	n.Taints = r.Taints
+	n.LastHeartbeat = time.Now().UTC()
+	m.setNodeStatus(n, node.Ready)
+	return n, nil
+}
+
+// Heartbeat records that the worker at address is alive.
+func (m *Manager) Heartbeat(address string) error {
+	n := m.getNode(address)
+	if n == nil || !m.isWorker(address) {
+		return fmt.Errorf("worker %s is not registered", address)
+	}
+
+	n.LastHeartbeat = time.Now().UTC()
+	m.setNodeStatus(n, node.Ready)
+	return nil
+}
+
+// LabelNode sets and removes labels of a node. Labels set this way last until
+// the worker registers again with the labels it was started with.
+func (m *Manager) LabelNode(name string, set map[string]string, remove []string) (*node.Node, error) {
+	n := m.getNode(name)
+	if n == nil {
+		return nil, fmt.Errorf("node %s not found", name)
+	}
+
+	labels := make(map[string]string)
+	for k, v := range n.Labels {
+		labels[k] = v
+	}
+	for k, v := range set {
+		if k == "" {
+			return nil, fmt.Errorf("label keys must not be empty")
+		}
+		labels[k] = v
+	}
+	for _, k := range remove {
+		delete(labels, k)
+	}
+	n.Labels = labels
+	log.Printf("labels of node %s are now %v\n", name, labels)
+	return n, nil
+}
+
+// TaintNode adds taints to a node and removes the taints with the keys, and
+// effects if given, of remove. A taint replaces the one with the same key and
+// effect. Taints set this way last until the worker registers again with
+// the taints it was started with.
+func (m *Manager) TaintNode(name string, add []node.Taint, remove []node.Taint) (*node.Node, error) {
+	n := m.getNode(name)
+	if n == nil {
+		return nil, fmt.Errorf("node %s not found", name)
+	}
+	for _, taint := range add {
+		if err := taint.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var taints []node.Taint
+	for _, taint := range n.Taints {
+		removed := false
+		for _, r := range append(remove, add...) {
+			if r.Key == taint.Key && (r.Effect == "" || r.Effect == taint.Effect) {
+				removed = true
+			}
+		}
+		if !removed {
+			taints = append(taints, taint)
+		}
+	}
+	n.Taints = append(taints, add...)
+	log.Printf("taints of node %s are now %v\n", name, n.Taints)
+	return n, nil
+}
+
+func (m *Manager) CheckWorkers() {
+	for {
+		m.mu.Lock()
+		m.checkWorkers()
+		m.evictTasks()
+		m.mu.Unlock()
+		m.sendStops()
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// checkWorkers marks the workers that stopped sending heartbeats NotReady or
+// Lost, and moves the tasks of workers that have been failing for longer than
+// the grace period to other workers. Lost workers are no longer polled for
+// task updates and have to register again to rejoin the cluster.
+func (m *Manager) checkWorkers() {
+	for _, n := range m.WorkerNodes {
+		// workers given with --workers don't send heartbeats, updateTasks
+		// tracks whether they can be reached instead
+		if !n.LastHeartbeat.IsZero() {
+			since := time.Since(n.LastHeartbeat)
+			switch {
+			case since > lostTimeout && n.Status != node.Lost:
+				log.Printf("worker %s missed heartbeats for %v\n", n.Name, since.Round(time.Second))
+				m.setNodeStatus(n, node.Lost)
+				m.removeWorker(n.Name)
+			case since > notReadyTimeout && n.Status == node.Ready:
+				log.Printf("worker %s missed heartbeats for %v\n", n.Name, since.Round(time.Second))
+				m.setNodeStatus(n, node.NotReady)
+			}
+		}
+
+		if n.Status != node.Ready && time.Since(n.StatusChanged) > m.NodeGracePeriod {
+			m.rescheduleTasks(n)
+		}
+	}
+}
+
+// rescheduleTasks moves the unfinished tasks of a failed worker to other
+// workers.
+func (m *Manager) rescheduleTasks(n *node.Node) {
+	ids := m.WorkerTaskMap[n.Name]
+	if len(ids) == 0 {
+		return
+	}
+	m.WorkerTaskMap[n.Name] = []uuid.UUID{}
+	n.TaskCount = 0
+
+	for _, id := range ids {
+		if m.TaskWorkerMap[id] == n.Name {
+			delete(m.TaskWorkerMap, id)
+		}
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if t.State != task.Scheduled && t.State != task.Running {
+			continue
+		}
+		m.rescheduleTask(t, n.Name, fmt.Sprintf("worker %s is %s", n.Name, n.Status))
+	}
+}
+
+// rescheduleTask moves a task that has been taken off a worker to another
+// one. Replicas of services are marked Failed so that the service replaces
+// them, other tasks are queued again under the same ID. The task is
+// remembered in LostTasks so that the copy left on the worker is stopped
+// and its state ignored. A task placed on the same worker again replaces the
+// copy there, as workers restart tasks they are sent again.
+func (m *Manager) rescheduleTask(t *task.Task, worker string, reason string) {
+	id := t.ID
+	m.LostTasks[id] = worker
+	t.Error = reason
+	t.ContainerID = ""
+	t.HostPorts = nil
+	if t.ServiceID != uuid.Nil {
+		log.Printf("task %s was taken off worker %s (%s), its service will replace it\n", id, worker, reason)
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		m.TaskDb.Put(id.String(), t)
+		return
+	}
+
+	log.Printf("rescheduling task %s taken off worker %s (%s)\n", id, worker, reason)
+	t.State = task.Pending
+	m.TaskDb.Put(id.String(), t)
+
+	pending := *t
+	pending.State = task.Scheduled
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now().UTC(),
+		Task:      pending,
+	})
+}
+
+// evictTasks takes the tasks that don't tolerate a NoExecute taint off their
+// node and reschedules them. The copies left behind are stopped.
+func (m *Manager) evictTasks() {
+	m.updateNodeAllocations()
+	for _, n := range m.WorkerNodes {
+		if n.Status != node.Ready {
+			continue
+		}
+		for _, t := range n.Tasks {
+			taints := n.Untolerated(*t, node.NoExecute)
+			if len(taints) == 0 {
+				continue
+			}
+
+			m.unassignTask(t.ID)
+			m.rescheduleTask(t, n.Name, fmt.Sprintf("evicted by taint %s", taints[0]))
+			m.stopLater(n.Name, t.ID)
+		}
+	}
+}
+
+// suppressDuplicate reports whether a task reported by a worker is a copy
+// left behind when the task was moved off it, and queues the copy to be
+// stopped if it is still running. The manager ignores the state of such
+// copies, and of the copies on a drained node while the task is being moved.
+func (m *Manager) suppressDuplicate(worker string, t *task.Task) bool {
+	if m.Moving[t.ID] == worker {
+		return true
+	}
+	if m.LostTasks[t.ID] != worker {
+		return false
+	}
+	if m.TaskWorkerMap[t.ID] == worker {
+		// rescheduled onto the same worker after it came back
+		delete(m.LostTasks, t.ID)
+		return false
+	}
+
+	if t.State == task.Scheduled || t.State == task.Running {
+		log.Printf("task %s was rescheduled off worker %s, stopping the copy still running there\n", t.ID, worker)
+		m.stopLater(worker, t.ID)
+	}
+	return true
+}
+
+func (m *Manager) setNodeStatus(n *node.Node, status string) {
+	if n.Status == status {
+		return
+	}
+	log.Printf("worker %s is %s\n", n.Name, status)
+	n.Status = status
+	n.StatusChanged = time.Now().UTC()
+}
+
+// readyNodes returns the nodes tasks can be scheduled on.
+func (m *Manager) readyNodes() []*node.Node {
+	var nodes []*node.Node
+	for _, n := range m.WorkerNodes {
+		if n.Status == node.Ready {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (m *Manager) getNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+func (m *Manager) isWorker(name string) bool {
+	for _, w := range m.Workers {
+		if w == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) removeWorker(name string) {
+	var workers []string
+	for _, w := range m.Workers {
+		if w != name {
+			workers = append(workers, w)
+		}
+	}
+	m.Workers = workers
+}
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// preempt evicts the tasks with a lower priority than t that the placement
+// picked to make room for it, and reports whether it did. The victims were
+// picked from a snapshot of the nodes, those that have left the node since
+// are skipped. The evicted tasks are rescheduled like tasks lost with a
+// worker, and each eviction is recorded as a Completed event of the evicted
+// task.
+func (m *Manager) preempt(t task.Task, p placement) bool {
+	if p.preempt == "" {
+		return false
+	}
+
+	for _, id := range p.victims {
+		if m.TaskWorkerMap[id] != p.preempt {
+			continue
+		}
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		v := result.(*task.Task)
+		if v.State != task.Scheduled && v.State != task.Running {
+			continue
+		}
+
+		reason := fmt.Sprintf("preempted by task %s with priority %d", t.ID, t.Priority)
+		log.Printf("task %s with priority %d on worker %s is %s\n", v.ID, v.Priority, p.preempt, reason)
+
+		te := task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Completed,
+			Timestamp: time.Now().UTC(),
+			Task:      *v,
+		}
+		te.Task.Error = reason
+		m.EventDb.Put(te.ID.String(), &te)
+
+		m.unassignTask(v.ID)
+		m.rescheduleTask(v, p.preempt, reason)
+		m.stopLater(p.preempt, v.ID)
+	}
+	return true
+}
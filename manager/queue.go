@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// PriorityQueue holds the task events waiting to be sent to workers. Events
+// of tasks with a higher Priority are dequeued first, events of the same
+// priority in the order they were queued. It is safe for concurrent use.
+type PriorityQueue struct {
+	mu    sync.Mutex
+	items []queueItem
+	seq   int
+}
+
+type queueItem struct {
+	event task.TaskEvent
+	seq   int
+	// notBefore holds back an event that was requeued with a delay
+	notBefore time.Time
+}
+
+// NewPriorityQueue returns an empty PriorityQueue.
+func NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{}
+}
+
+func (q *PriorityQueue) Enqueue(te task.TaskEvent) {
+	q.Requeue(te, 0)
+}
+
+// Requeue queues an event again, holding it back for delay so that the
+// events behind it get their turn.
+func (q *PriorityQueue) Requeue(te task.TaskEvent, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	item := queueItem{event: te, seq: q.seq}
+	if delay > 0 {
+		item.notBefore = time.Now().Add(delay)
+	}
+	q.items = append(q.items, item)
+}
+
+// Dequeue removes and returns the event with the highest priority that isn't
+// held back. It returns false if there is none.
+func (q *PriorityQueue) Dequeue() (task.TaskEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	best := -1
+	for i, item := range q.items {
+		if item.notBefore.After(now) {
+			continue
+		}
+		if best < 0 || item.before(q.items[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return task.TaskEvent{}, false
+	}
+
+	te := q.items[best].event
+	q.items = append(q.items[:best], q.items[best+1:]...)
+	return te, true
+}
+
+// Remove takes the events of the task with the ID off the queue and returns
+// how many there were.
+func (q *PriorityQueue) Remove(id uuid.UUID) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var items []queueItem
+	for _, item := range q.items {
+		if item.event.Task.ID != id {
+			items = append(items, item)
+		}
+	}
+	removed := len(q.items) - len(items)
+	q.items = items
+	return removed
+}
+
+// Len returns the number of queued events, including the held back ones.
+func (q *PriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (i queueItem) before(other queueItem) bool {
+	if i.event.Task.Priority != other.event.Task.Priority {
+		return i.event.Task.Priority > other.event.Task.Priority
+	}
+	return i.seq < other.seq
+}
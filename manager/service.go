@@ -0,0 +1,517 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxSurge             = 1
+	defaultHealthTimeout        = 60
+	defaultMonitor              = 30
+	defaultRevisionHistoryLimit = 10
+)
+
+func (m *Manager) AddService(s *task.Service) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+	if _, err := m.GetService(s.Name); err == nil {
+		return fmt.Errorf("service %s already exists", s.Name)
+	}
+
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	setServiceDefaults(s)
+	s.CreatedTime = time.Now().UTC()
+	s.Tasks = nil
+	s.Revision = 1
+	s.Revisions = []task.ServiceRevision{{Revision: 1, Task: s.Task, CreatedTime: s.CreatedTime}}
+	s.Rollout = task.RolloutStatus{Status: task.RolloutComplete, UpdatedTime: s.CreatedTime}
+
+	m.reconcileService(s)
+	return m.ServiceDb.Put(s.ID.String(), s)
+}
+
+func setServiceDefaults(s *task.Service) {
+	if s.UpdateConfig.MaxSurge == 0 && s.UpdateConfig.MaxUnavailable == 0 {
+		s.UpdateConfig.MaxSurge = defaultMaxSurge
+	}
+	if s.UpdateConfig.HealthTimeout == 0 {
+		s.UpdateConfig.HealthTimeout = defaultHealthTimeout
+	}
+	if s.UpdateConfig.Monitor == 0 {
+		s.UpdateConfig.Monitor = defaultMonitor
+	}
+	if s.UpdateConfig.FailureAction == "" {
+		s.UpdateConfig.FailureAction = task.FailurePause
+	}
+	if s.RevisionHistoryLimit == 0 {
+		s.RevisionHistoryLimit = defaultRevisionHistoryLimit
+	}
+}
+
+// UpdateService applies a new specification to a service. A changed task
+// template becomes a new revision that is rolled out gradually. replicas is
+// nil when the specification leaves the number of replicas out, which keeps
+// the current one.
+func (m *Manager) UpdateService(key string, spec *task.Service, replicas *int) (*task.Service, error) {
+	s, err := m.GetService(key)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Name != "" && spec.Name != s.Name {
+		return nil, fmt.Errorf("service %s can't be renamed", s.Name)
+	}
+	spec.Name = s.Name
+	if replicas == nil {
+		spec.Replicas = s.Replicas
+	}
+	err = spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Replicas = spec.Replicas
+	s.UpdateConfig = spec.UpdateConfig
+	s.RevisionHistoryLimit = spec.RevisionHistoryLimit
+	setServiceDefaults(s)
+	if !reflect.DeepEqual(s.Task, spec.Task) {
+		m.newRevision(s, spec.Task, task.RolloutProgressing, "")
+	}
+
+	m.reconcileService(s)
+	return s, m.ServiceDb.Put(s.ID.String(), s)
+}
+
+// RollbackService restores the template of an earlier revision, the previous
+// one if revision is 0, as a new revision.
+func (m *Manager) RollbackService(key string, revision int) (*task.Service, error) {
+	s, err := m.GetService(key)
+	if err != nil {
+		return nil, err
+	}
+	if revision == 0 {
+		revision = s.PreviousRevision()
+	}
+	if revision == s.Revision {
+		return nil, fmt.Errorf("revision %d is the current revision of service %s", revision, s.Name)
+	}
+	t, err := s.TemplateOf(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	m.newRevision(s, *t, task.RolloutRollingBack, fmt.Sprintf("rolling back to revision %d", revision))
+	m.reconcileService(s)
+	return s, m.ServiceDb.Put(s.ID.String(), s)
+}
+
+// ResumeService continues a paused rollout.
+func (m *Manager) ResumeService(key string) (*task.Service, error) {
+	s, err := m.GetService(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.Rollout.Status != task.RolloutPaused {
+		return nil, fmt.Errorf("rollout of service %s isn't paused", s.Name)
+	}
+
+	s.Rollout = task.RolloutStatus{
+		Status:      task.RolloutProgressing,
+		Message:     fmt.Sprintf("resumed rollout of revision %d", s.Revision),
+		UpdatedTime: time.Now().UTC(),
+	}
+	m.reconcileService(s)
+	return s, m.ServiceDb.Put(s.ID.String(), s)
+}
+
+func (m *Manager) newRevision(s *task.Service, t task.Task, status string, msg string) {
+	now := time.Now().UTC()
+	s.Revision = s.Revisions[len(s.Revisions)-1].Revision + 1
+	s.Task = t
+	s.Revisions = append(s.Revisions, task.ServiceRevision{Revision: s.Revision, Task: t, CreatedTime: now})
+	if len(s.Revisions) > s.RevisionHistoryLimit {
+		s.Revisions = s.Revisions[len(s.Revisions)-s.RevisionHistoryLimit:]
+	}
+
+	if msg == "" {
+		msg = fmt.Sprintf("rolling out revision %d", s.Revision)
+	}
+	s.Rollout = task.RolloutStatus{Status: status, Message: msg, UpdatedTime: now}
+	log.Printf("service %s: %s\n", s.Name, msg)
+}
+
+func (m *Manager) GetServices() []*task.Service {
+	serviceList, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("error getting list of services: %v\n", err)
+		return nil
+	}
+
+	return serviceList.([]*task.Service)
+}
+
+// GetService looks a service up by its ID or its name.
+func (m *Manager) GetService(key string) (*task.Service, error) {
+	if id, err := uuid.Parse(key); err == nil {
+		result, err := m.ServiceDb.Get(id.String())
+		if err == nil {
+			return result.(*task.Service), nil
+		}
+	}
+
+	for _, s := range m.GetServices() {
+		if s.Name == key {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("service %s not found", key)
+}
+
+// ScaleService changes the number of replicas of a service and starts or
+// stops tasks to match it.
+func (m *Manager) ScaleService(key string, replicas int) (*task.Service, error) {
+	if replicas < 0 {
+		return nil, fmt.Errorf("replicas must not be negative")
+	}
+	s, err := m.GetService(key)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("scaling service %s from %d to %d replicas\n", s.Name, s.Replicas, replicas)
+	s.Replicas = replicas
+	m.reconcileService(s)
+	return s, m.ServiceDb.Put(s.ID.String(), s)
+}
+
+// DeleteService stops the tasks of a service, whatever their state, and
+// removes it.
+func (m *Manager) DeleteService(key string) error {
+	s, err := m.GetService(key)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range s.Tasks {
+		m.stopDeletedServiceTask(s, id)
+	}
+	return m.ServiceDb.Delete(s.ID.String())
+}
+
+// stopDeletedServiceTask stops a task of a deleted service. A running task
+// is stopped, a task still waiting in the queue is taken off it, and a task
+// that was sent to a worker but hasn't started yet is treated like a task
+// lost with a worker, so that updateTasks stops it once the worker reports
+// it.
+func (m *Manager) stopDeletedServiceTask(s *task.Service, id uuid.UUID) {
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		m.Pending.Remove(id)
+		return
+	}
+	t := result.(*task.Task)
+
+	switch t.State {
+	case task.Running:
+		if m.requestStop(id) {
+			log.Printf("stopping task %s of deleted service %s\n", id, s.Name)
+		}
+	case task.Pending, task.Scheduled:
+		m.Pending.Remove(id)
+		if w, ok := m.TaskWorkerMap[id]; ok {
+			m.unassignTask(id)
+			m.LostTasks[id] = w
+		}
+		log.Printf("cancelling task %s of deleted service %s\n", id, s.Name)
+		t.State = task.Completed
+		t.Error = fmt.Sprintf("service %s was deleted", s.Name)
+		t.FinishTime = time.Now().UTC()
+		m.TaskDb.Put(id.String(), t)
+	}
+}
+
+// ReconcileServices reconciles every service periodically. It holds the
+// manager lock like the API handlers that change services, so that a
+// service is only ever read, reconciled and stored by one of them at a time.
+// The tasks being rolled out are health checked before, without the lock.
+func (m *Manager) ReconcileServices() {
+	for {
+		m.mu.Lock()
+		probes := m.rolloutProbes()
+		m.mu.Unlock()
+		m.probeHealth(probes)
+
+		m.mu.Lock()
+		m.reconcileServices()
+		m.mu.Unlock()
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// rolloutProbes returns the health checks of the running tasks of revisions
+// being rolled out.
+func (m *Manager) rolloutProbes() []healthProbe {
+	var probes []healthProbe
+	for _, s := range m.GetServices() {
+		if !s.RollingOut() {
+			continue
+		}
+		for _, id := range s.Tasks {
+			result, err := m.TaskDb.Get(id.String())
+			if err != nil {
+				continue
+			}
+			t := result.(*task.Task)
+			if !s.IsCurrent(t.ServiceRevision) {
+				continue
+			}
+			if p, ok := m.healthProbeOf(t); ok {
+				probes = append(probes, p)
+			}
+		}
+	}
+	return probes
+}
+
+func (m *Manager) reconcileServices() {
+	for _, s := range m.GetServices() {
+		m.reconcileService(s)
+		m.ServiceDb.Put(s.ID.String(), s)
+	}
+}
+
+// reconcileService compares the tasks of a service that are pending,
+// scheduled or running with its desired number of replicas, starting new
+// tasks when there are too few and stopping the newest running ones when
+// there are too many. Tasks of older revisions are replaced as allowed by the
+// service's UpdateConfig.
+func (m *Manager) reconcileService(s *task.Service) {
+	var current, old []*task.Task
+	failed := 0
+	for _, id := range s.Tasks {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			log.Printf("task %s of service %s not found\n", id, s.Name)
+			continue
+		}
+
+		t := result.(*task.Task)
+		switch t.State {
+		case task.Pending, task.Scheduled, task.Running:
+			if s.IsCurrent(t.ServiceRevision) {
+				current = append(current, t)
+			} else {
+				old = append(old, t)
+			}
+		default:
+			if t.State == task.Failed && s.IsCurrent(t.ServiceRevision) {
+				failed++
+			}
+			log.Printf("task %s of service %s is %v, it will be replaced\n", id, s.Name, t.State)
+		}
+	}
+
+	switch {
+	case s.Rollout.Status == task.RolloutPaused:
+		current, old = m.holdService(s, current, old)
+	case s.RollingOut():
+		current, old = m.rollService(s, current, old, failed)
+	default:
+		for len(current)+len(old) < s.Replicas {
+			current = append(current, m.startServiceTask(s))
+		}
+		current = m.stopServiceTasks(s, current, len(current)+len(old)-s.Replicas)
+	}
+
+	s.Tasks = nil
+	for _, t := range append(old, current...) {
+		s.Tasks = append(s.Tasks, t.ID)
+	}
+}
+
+// rollService takes one step of a rollout: it starts tasks of the current
+// revision while staying within MaxSurge and stops tasks of older revisions
+// while staying within MaxUnavailable. New tasks count as available once they
+// pass their health check and have kept running for the Monitor period. The
+// rollout is complete when all replicas of the current revision are
+// available.
+func (m *Manager) rollService(s *task.Service, current, old []*task.Task, failed int) ([]*task.Task, []*task.Task) {
+	if failed > 0 {
+		m.failRollout(s, fmt.Sprintf("%d tasks of revision %d failed", failed, s.Revision))
+		return current, old
+	}
+
+	ready := 0
+	for _, t := range current {
+		if m.taskReady(t, s.UpdateConfig.Monitor) {
+			ready++
+			continue
+		}
+		timeout := time.Duration(s.UpdateConfig.HealthTimeout+s.UpdateConfig.Monitor) * time.Second
+		if t.State == task.Running && time.Since(t.StartTime) > timeout {
+			m.failRollout(s, fmt.Sprintf("task %s of revision %d didn't pass its health check within %v", t.ID, s.Revision, timeout))
+			return current, old
+		}
+	}
+	if len(old) == 0 && ready >= s.Replicas {
+		s.Rollout = task.RolloutStatus{
+			Status:      task.RolloutComplete,
+			Message:     fmt.Sprintf("revision %d rolled out", s.Revision),
+			UpdatedTime: time.Now().UTC(),
+		}
+		log.Printf("service %s: %s\n", s.Name, s.Rollout.Message)
+	}
+
+	available := ready
+	for _, t := range old {
+		if t.State == task.Running {
+			available++
+		}
+	}
+
+	for len(current) < s.Replicas && len(current)+len(old) < s.Replicas+s.UpdateConfig.MaxSurge {
+		current = append(current, m.startServiceTask(s))
+	}
+
+	minAvailable := s.Replicas - s.UpdateConfig.MaxUnavailable
+	for i := len(old) - 1; i >= 0 && available > minAvailable; i-- {
+		if old[i].State == task.Running && m.requestStop(old[i].ID) {
+			log.Printf("stopping task %s of revision %d of service %s\n", old[i].ID, old[i].ServiceRevision, s.Name)
+			old = append(old[:i], old[i+1:]...)
+			available--
+		}
+	}
+
+	current = m.stopServiceTasks(s, current, len(current)-s.Replicas)
+	return current, old
+}
+
+// holdService keeps the replicas of a service whose rollout is paused
+// running without moving any more of them to the current revision. Missing
+// replicas are replaced with tasks of the newest older revision still
+// running, or of the current revision if none is left, and extra replicas
+// are stopped newest revision first.
+func (m *Manager) holdService(s *task.Service, current, old []*task.Task) ([]*task.Task, []*task.Task) {
+	for len(current)+len(old) < s.Replicas {
+		if len(old) == 0 {
+			current = append(current, m.startServiceTask(s))
+			continue
+		}
+		revision := old[len(old)-1].ServiceRevision
+		t, err := s.TemplateOf(revision)
+		if err != nil {
+			revision, t = s.Revision, &s.Task
+		}
+		started := m.startRevisionTask(s, revision, *t)
+		if s.IsCurrent(revision) {
+			current = append(current, started)
+		} else {
+			old = append(old, started)
+		}
+	}
+
+	extra := len(current) + len(old) - s.Replicas
+	before := len(current)
+	current = m.stopServiceTasks(s, current, extra)
+	old = m.stopServiceTasks(s, old, extra-(before-len(current)))
+	return current, old
+}
+
+// failRollout rolls back to the previous revision if the service asks for it
+// and the failed rollout isn't a rollback itself, and pauses it otherwise.
+func (m *Manager) failRollout(s *task.Service, reason string) {
+	previous := s.PreviousRevision()
+	if s.UpdateConfig.FailureAction == task.FailureRollback && s.Rollout.Status == task.RolloutProgressing && previous > 0 {
+		t, _ := s.TemplateOf(previous)
+		m.newRevision(s, *t, task.RolloutRollingBack, fmt.Sprintf("%s, rolling back to revision %d", reason, previous))
+		return
+	}
+
+	s.Rollout = task.RolloutStatus{
+		Status:      task.RolloutPaused,
+		Message:     fmt.Sprintf("%s, rollout paused", reason),
+		UpdatedTime: time.Now().UTC(),
+	}
+	log.Printf("service %s: %s\n", s.Name, s.Rollout.Message)
+}
+
+// inRollout reports whether a task belongs to a revision that is being
+// rolled out, in which case the rollout decides what to do with it.
+func (m *Manager) inRollout(t *task.Task) bool {
+	result, err := m.ServiceDb.Get(t.ServiceID.String())
+	if err != nil {
+		return false
+	}
+	s := result.(*task.Service)
+	return s.RollingOut() && s.IsCurrent(t.ServiceRevision)
+}
+
+// taskReady reports whether a task has been running for at least monitor
+// seconds and passed its latest health check, made by probeHealth. Like the
+// periodic health checks, it counts a task without a health check or a
+// published port to reach it on as healthy.
+func (m *Manager) taskReady(t *task.Task, monitor int) bool {
+	if t.State != task.Running || time.Since(t.StartTime) < time.Duration(monitor)*time.Second {
+		return false
+	}
+	if !hasHealthCheck(t) {
+		return true
+	}
+	err, ok := m.health[t.ID]
+	return ok && err == nil
+}
+
+// stopServiceTasks stops up to n of the newest running tasks and returns the
+// remaining ones.
+func (m *Manager) stopServiceTasks(s *task.Service, tasks []*task.Task, n int) []*task.Task {
+	for i := len(tasks) - 1; i >= 0 && n > 0; i-- {
+		if m.requestStop(tasks[i].ID) {
+			log.Printf("stopping task %s of service %s\n", tasks[i].ID, s.Name)
+			tasks = append(tasks[:i], tasks[i+1:]...)
+			n--
+		}
+	}
+	return tasks
+}
+
+// startServiceTask queues a new task of the service's current revision. The
+// task is stored as pending right away so that the reconciler can follow it
+// before it is scheduled.
+func (m *Manager) startServiceTask(s *task.Service) *task.Task {
+	return m.startRevisionTask(s, s.Revision, s.Task)
+}
+
+// startRevisionTask queues a new task of the service from the template of
+// revision, like startServiceTask.
+func (m *Manager) startRevisionTask(s *task.Service, revision int, template task.Task) *task.Task {
+	t := template
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.State = task.Scheduled
+	t.Type = task.TypeService
+	t.ServiceID = s.ID
+	t.ServiceRevision = revision
+
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+	})
+	log.Printf("service %s started task %s of revision %d\n", s.Name, t.ID, revision)
+
+	pending := t
+	pending.State = task.Pending
+	m.TaskDb.Put(pending.ID.String(), &pending)
+	return &pending
+}
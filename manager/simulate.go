@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"fmt"
+
+	"cube/node"
+	"cube/scheduler"
+	"cube/task"
+)
+
+// Simulation is where a task would be placed and why.
+type Simulation struct {
+	// Node the task would be placed on, empty if no node can take it
+	Node string
+	// Scores of the candidate nodes given by the scheduler
+	Scores map[string]float64
+	// Reasons the other nodes were filtered out for
+	Reasons map[string]string
+	Error   string
+}
+
+// Simulate runs the scheduler for the task against the current state of the
+// nodes without placing it. Nothing is queued or stored, and the scheduler
+// used is a copy so that its state, such as the RoundRobin cursor, is left
+// alone. The manager lock is only held to copy the nodes, an extender is
+// called without it.
+func (m *Manager) Simulate(t task.Task) *Simulation {
+	result := &Simulation{
+		Scores:  make(map[string]float64),
+		Reasons: make(map[string]string),
+	}
+
+	m.mu.Lock()
+	nodes := m.schedulingNodes()
+	s := scheduler.Copy(m.Scheduler)
+	for _, n := range m.WorkerNodes {
+		if n.Status != node.Ready {
+			result.Reasons[n.Name] = fmt.Sprintf("node is %s", n.Status)
+		}
+	}
+	m.mu.Unlock()
+
+	candidates := s.SelectCandidateNodes(t, nodes)
+	var rejected map[string]string
+	if e, ok := s.(*scheduler.Extender); ok {
+		rejected = e.Failed
+	}
+	selected := make(map[string]bool)
+	for _, n := range candidates {
+		selected[n.Name] = true
+	}
+	for _, n := range nodes {
+		if selected[n.Name] {
+			continue
+		}
+		reason := filterReason(t, n, nodes, rejected)
+		if reason == "" {
+			reason = "filtered out by the scheduler"
+		}
+		result.Reasons[n.Name] = reason
+	}
+
+	if len(candidates) == 0 {
+		result.Error = unschedulableReason(t, nodes, rejected)
+		return result
+	}
+	result.Scores = s.Score(t, candidates)
+	picked := s.Pick(result.Scores, candidates)
+	if picked == nil {
+		result.Error = "no candidate could be scored"
+		return result
+	}
+	result.Node = picked.Name
+	return result
+}
@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cube/node"
+)
+
+// statsInterval is how often the manager refreshes the stats of the
+// workers. It has to stay well below node.StatsMaxAge.
+const statsInterval = 15 * time.Second
+
+// CollectStats keeps the stats of the workers cached for the schedulers,
+// which score nodes from the cache instead of asking the workers while a
+// task waits to be placed.
+func (m *Manager) CollectStats() {
+	for {
+		m.collectStats()
+		time.Sleep(statsInterval)
+	}
+}
+
+// collectStats refreshes the stats of all the workers that aren't lost at
+// the same time, so that a slow worker doesn't hold up the others.
+func (m *Manager) collectStats() {
+	var nodes []*node.Node
+	m.mu.Lock()
+	for _, n := range m.WorkerNodes {
+		if n.Status != node.Lost {
+			nodes = append(nodes, n)
+		}
+	}
+	m.mu.Unlock()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *node.Node) {
+			defer wg.Done()
+			s, err := n.FetchStats(client)
+			if err != nil {
+				log.Printf("error refreshing stats of node %s: %v\n", n.Name, err)
+				return
+			}
+			// the schedulers read the node while the manager is locked
+			m.mu.Lock()
+			n.SetStats(s)
+			m.mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+}
@@ -7,38 +7,144 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"cube/stats"
-	"cube/utils"
+	"cube/task"
+)
+
+// Node statuses. A worker that misses its heartbeats is NotReady, and Lost
+// once it has missed them for long enough to be considered gone.
+const (
+	Ready    = "Ready"
+	NotReady = "NotReady"
+	Lost     = "Lost"
 )
 
 type Node struct {
 	Name            string
 	Ip              string
 	Api             string
+	// Capacity and allocations: Cpu in cores, Memory in KiB, Disk in bytes
+	Cpu             float64
+	CpuAllocated    float64
 	Memory          int64
 	MemoryAllocated int64
 	Disk            int64
 	DiskAllocated   int64
+	PortsAllocated  []task.HostPort
+	// Tasks scheduled or running on the node, for placing tasks relative to
+	// each other
+	Tasks           []*task.Task `json:"-"`
 	Stats           stats.Stats
+	// CpuUsage is the share of the node's CPU time that was busy between
+	// the last two refreshes of its stats, from 0 to 1
+	CpuUsage        float64
+	StatsUpdated    time.Time
 	Role            string
 	TaskCount       int
+	WorkerName      string
+	Labels          map[string]string
+	Taints          []Taint
+	Status          string
+	StatusChanged   time.Time
+	// Unschedulable nodes are skipped when scheduling new tasks
+	Unschedulable bool
+	DrainStatus   string
+	DrainMessage  string
+	// LastHeartbeat is zero for workers that don't send heartbeats
+	LastHeartbeat time.Time
+}
+
+// StatsMaxAge is how long a node's stats are used for scheduling before the
+// node counts as stale and is avoided.
+const StatsMaxAge = 45 * time.Second
+
+// Drain states of a Node.
+const (
+	Draining    = "Draining"
+	Drained     = "Drained"
+	DrainFailed = "Failed"
+)
+
+// Registration is sent by a worker to join a manager's cluster.
+type Registration struct {
+	Name string
+	// Address is the host:port the manager reaches the worker's API at
+	Address string
+	Cpu     float64
+	// Memory in KiB and Disk in bytes, as reported by the worker's stats
+	Memory int64
+	Disk   int64
+	Labels map[string]string
+	Taints []Taint
 }
 
 func NewNode(name string, api string, role string) *Node {
 	return &Node{
-		Name: name,
-		Api:  api,
-		Role: role,
+		Name:          name,
+		Api:           api,
+		Role:          role,
+		Status:        Ready,
+		StatusChanged: time.Now().UTC(),
+	}
+}
+
+// SetTasks records the tasks scheduled or running on the node, and the CPU,
+// memory, disk and host ports they allocate.
+func (n *Node) SetTasks(tasks []*task.Task) {
+	n.Tasks = tasks
+	n.TaskCount = len(tasks)
+	n.CpuAllocated = 0
+	n.MemoryAllocated = 0
+	n.DiskAllocated = 0
+	n.PortsAllocated = nil
+	for _, t := range tasks {
+		n.CpuAllocated += t.Cpu
+		// task memory is in bytes, node memory in KiB
+		n.MemoryAllocated += t.Memory / 1024
+		n.DiskAllocated += t.Disk
+		ports, err := task.HostPorts(t.PortBindings)
+		if err != nil {
+			continue
+		}
+		n.PortsAllocated = append(n.PortsAllocated, ports...)
 	}
 }
 
-func (n *Node) GetStats() (*stats.Stats, error) {
+// FetchStats fetches the node's stats once. The node itself is left alone so
+// that it can be read while the stats are fetched, SetStats records them.
+func (n *Node) FetchStats(client *http.Client) (*stats.Stats, error) {
+	return n.getStats(client.Get)
+}
+
+// SetStats records stats fetched from the node, along with its capacity and
+// its CPU usage since the previous stats. StatsUpdated records when.
+func (n *Node) SetStats(s *stats.Stats) {
+	if n.Stats.CpuStats != nil && s.CpuStats != nil {
+		n.CpuUsage = stats.CpuUsageBetween(&n.Stats, s)
+	}
+	n.Memory = int64(s.MemTotalKb())
+	n.Disk = int64(s.DiskTotal())
+	if s.CpuCount > 0 {
+		n.Cpu = float64(s.CpuCount)
+	}
+	n.Stats = *s
+	n.StatsUpdated = time.Now().UTC()
+}
+
+// StatsStale reports whether the node's stats haven't been refreshed for
+// longer than StatsMaxAge, or ever.
+func (n *Node) StatsStale() bool {
+	return n.StatsUpdated.IsZero() || time.Since(n.StatsUpdated) > StatsMaxAge
+}
+
+func (n *Node) getStats(get func(string) (*http.Response, error)) (*stats.Stats, error) {
 	var resp *http.Response
 	var err error
 
 	url := fmt.Sprintf("%s/stats", n.Api)
-	resp, err = utils.HTTPWithRetry(http.Get, url)
+	resp, err = get(url)
 	if err != nil {
 		msg := fmt.Sprintf("Unable to connect to %v. Permanent failure.\n", n.Api)
 		log.Println(msg)
@@ -65,9 +171,5 @@ func (n *Node) GetStats() (*stats.Stats, error) {
 		return nil, fmt.Errorf("error getting stats from node %s", n.Name)
 	}
 
-	n.Memory = int64(stats.MemTotalKb())
-	n.Disk = int64(stats.DiskTotal())
-	n.Stats = stats
-
-	return &n.Stats, nil
-}
\ No newline at end of file
+	return &stats, nil
+}
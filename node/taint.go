@@ -0,0 +1,74 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	"cube/task"
+)
+
+// Effects of a Taint on the tasks that don't tolerate it.
+const (
+	// NoSchedule keeps new tasks off the node
+	NoSchedule = "NoSchedule"
+	// PreferNoSchedule places new tasks elsewhere when possible
+	PreferNoSchedule = "PreferNoSchedule"
+	// NoExecute keeps new tasks off the node and evicts the running ones
+	NoExecute = "NoExecute"
+)
+
+// Taint repels the tasks that don't tolerate it from a node.
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+func (t Taint) Validate() error {
+	if t.Key == "" {
+		return fmt.Errorf("taint key is required")
+	}
+	switch t.Effect {
+	case NoSchedule, PreferNoSchedule, NoExecute:
+	default:
+		return fmt.Errorf("unknown taint effect %q on %s", t.Effect, t.Key)
+	}
+	return nil
+}
+
+// String formats the taint as key=value:Effect, or key:Effect without a
+// value.
+func (t Taint) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
+
+// ParseTaint parses a taint in key=value:Effect or key:Effect form.
+func ParseTaint(s string) (Taint, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok {
+		return Taint{}, fmt.Errorf("taint %q must be in key=value:Effect form", s)
+	}
+	key, value, _ := strings.Cut(kv, "=")
+	taint := Taint{Key: key, Value: value, Effect: effect}
+	return taint, taint.Validate()
+}
+
+// ToleratedBy reports whether the task tolerates the taint.
+func (t Taint) ToleratedBy(tk task.Task) bool {
+	return tk.Tolerates(t.Key, t.Value, t.Effect)
+}
+
+// Untolerated returns the taints of the node with the effect that the task
+// doesn't tolerate.
+func (n *Node) Untolerated(t task.Task, effect string) []Taint {
+	var taints []Taint
+	for _, taint := range n.Taints {
+		if taint.Effect == effect && !taint.ToleratedBy(t) {
+			taints = append(taints, taint)
+		}
+	}
+	return taints
+}
@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cube/node"
+	"cube/task"
+)
+
+// MaxExtenderScore is the highest score an extender gives a node.
+const MaxExtenderScore = 10.0
+
+// ExtenderArgs is the body of the requests to an extender's /filter and
+// /score endpoints.
+type ExtenderArgs struct {
+	Task  task.Task
+	Nodes []*node.Node
+}
+
+// ExtenderFilterResult is an extender's response to /filter. Nodes lists the
+// names of the nodes the task can be placed on, FailedNodes why the others
+// can't take it.
+type ExtenderFilterResult struct {
+	Nodes       []string
+	FailedNodes map[string]string
+	Error       string
+}
+
+// ExtenderScoreResult is an extender's response to /score, with a score from
+// 0 to MaxExtenderScore for each node, the higher the better.
+type ExtenderScoreResult struct {
+	Scores map[string]float64
+	Error  string
+}
+
+// Extender adds the filter and score phases of an external HTTP service to
+// a built-in scheduler, for placement rules that don't belong in cube. The
+// nodes the built-in scheduler selects are filtered by the extender, and the
+// extender's scores are merged with the built-in ones: the built-in scores
+// are rescaled from 0 for the worst candidate to 1 for the best, and the
+// extender's score over MaxExtenderScore, times Weight, is added to them.
+// If the extender fails or doesn't answer within Timeout, the built-in
+// results are used as they are.
+type Extender struct {
+	Name    string
+	Base    Scheduler
+	URL     string
+	Timeout time.Duration
+	Weight  float64
+	// Failed holds why the extender rejected each node in the last filter
+	Failed map[string]string
+}
+
+// NewExtender returns an Extender calling the service at url on top of base.
+func NewExtender(base Scheduler, url string, timeout time.Duration, weight float64) *Extender {
+	return &Extender{
+		Name:    "extender",
+		Base:    base,
+		URL:     strings.TrimSuffix(url, "/"),
+		Timeout: timeout,
+		Weight:  weight,
+	}
+}
+
+func (e *Extender) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	candidates := e.Base.SelectCandidateNodes(t, nodes)
+	e.Failed = nil
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	result := ExtenderFilterResult{}
+	err := e.call("filter", ExtenderArgs{Task: t, Nodes: candidates}, &result)
+	if err == nil && result.Error != "" {
+		err = fmt.Errorf("%s", result.Error)
+	}
+	if err != nil {
+		log.Printf("extender filter failed, keeping all %d candidates: %v\n", len(candidates), err)
+		return candidates
+	}
+
+	keep := make(map[string]bool)
+	for _, name := range result.Nodes {
+		keep[name] = true
+	}
+	var filtered []*node.Node
+	for _, n := range candidates {
+		if keep[n.Name] {
+			filtered = append(filtered, n)
+		}
+	}
+	e.Failed = result.FailedNodes
+	return filtered
+}
+
+func (e *Extender) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := e.Base.Score(t, nodes)
+
+	result := ExtenderScoreResult{}
+	err := e.call("score", ExtenderArgs{Task: t, Nodes: nodes}, &result)
+	if err == nil && result.Error != "" {
+		err = fmt.Errorf("%s", result.Error)
+	}
+	if err != nil {
+		log.Printf("extender score failed, using the built-in scores: %v\n", err)
+		return scores
+	}
+
+	merged := normalizeScores(scores, e.Base.HigherIsBetter())
+	for name := range merged {
+		merged[name] += e.Weight * result.Scores[name] / MaxExtenderScore
+		if !e.Base.HigherIsBetter() {
+			// back to a cost for the built-in Pick
+			merged[name] = 1 + e.Weight - merged[name]
+		}
+	}
+	return merged
+}
+
+func (e *Extender) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return e.Base.Pick(scores, candidates)
+}
+
+func (e *Extender) HigherIsBetter() bool {
+	return e.Base.HigherIsBetter()
+}
+
+// normalizeScores rescales scores so that the best one is 1 and the worst
+// one 0. When all scores are the same they are all 1.
+func normalizeScores(scores map[string]float64, higherIsBetter bool) map[string]float64 {
+	first := true
+	var lowest, highest float64
+	for _, score := range scores {
+		if first || score < lowest {
+			lowest = score
+		}
+		if first || score > highest {
+			highest = score
+		}
+		first = false
+	}
+
+	normalized := make(map[string]float64)
+	for name, score := range scores {
+		switch {
+		case highest == lowest:
+			normalized[name] = 1
+		case higherIsBetter:
+			normalized[name] = (score - lowest) / (highest - lowest)
+		default:
+			normalized[name] = (highest - score) / (highest - lowest)
+		}
+	}
+	return normalized
+}
+
+// call posts args to an endpoint of the extender and decodes its response
+// into result.
+func (e *Extender) call(endpoint string, args ExtenderArgs, result interface{}) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: e.Timeout}
+	url := fmt.Sprintf("%s/%s", e.URL, endpoint)
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
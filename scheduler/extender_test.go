@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cube/node"
+	"cube/task"
+)
+
+// stubExtender serves /filter and /score with fixed results, after an
+// optional delay.
+func stubExtender(t *testing.T, filter ExtenderFilterResult, score ExtenderScoreResult, delay time.Duration) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter", func(w http.ResponseWriter, r *http.Request) {
+		args := ExtenderArgs{}
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			t.Errorf("decoding filter request: %v", err)
+		}
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(filter)
+	})
+	mux.HandleFunc("/score", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		json.NewEncoder(w).Encode(score)
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testNodes() []*node.Node {
+	return []*node.Node{
+		node.NewNode("a", "http://a", "worker"),
+		node.NewNode("b", "http://b", "worker"),
+	}
+}
+
+func names(nodes []*node.Node) []string {
+	var n []string
+	for _, node := range nodes {
+		n = append(n, node.Name)
+	}
+	return n
+}
+
+func TestExtenderFilter(t *testing.T) {
+	s := stubExtender(t, ExtenderFilterResult{
+		Nodes:       []string{"b"},
+		FailedNodes: map[string]string{"a": "no gpu"},
+	}, ExtenderScoreResult{}, 0)
+	e := NewExtender(&Greedy{Name: "greedy"}, s.URL, time.Second, 1)
+
+	candidates := e.SelectCandidateNodes(task.Task{}, testNodes())
+	if len(candidates) != 1 || candidates[0].Name != "b" {
+		t.Fatalf("candidates = %v, want [b]", names(candidates))
+	}
+	if e.Failed["a"] != "no gpu" {
+		t.Errorf("Failed = %v, want a rejected for no gpu", e.Failed)
+	}
+}
+
+func TestExtenderScore(t *testing.T) {
+	s := stubExtender(t, ExtenderFilterResult{}, ExtenderScoreResult{
+		Scores: map[string]float64{"a": 0, "b": MaxExtenderScore},
+	}, 0)
+
+	for _, base := range []Scheduler{&Greedy{Name: "greedy"}, &Epvm{Name: "epvm"}, NewBinPack()} {
+		e := NewExtender(base, s.URL, time.Second, 1)
+		nodes := testNodes()
+		picked := e.Pick(e.Score(task.Task{}, nodes), nodes)
+		if picked == nil || picked.Name != "b" {
+			t.Errorf("%T: picked %v, want b", base, picked)
+		}
+	}
+}
+
+func TestExtenderScoreOutweighsBase(t *testing.T) {
+	s := stubExtender(t, ExtenderFilterResult{}, ExtenderScoreResult{
+		Scores: map[string]float64{"a": 0, "b": MaxExtenderScore},
+	}, 0)
+	nodes := testNodes()
+	// the greedy scheduler prefers a, the idler node
+	nodes[1].CpuUsage = 0.5
+
+	e := NewExtender(&Greedy{Name: "greedy"}, s.URL, time.Second, 2)
+	picked := e.Pick(e.Score(task.Task{}, nodes), nodes)
+	if picked == nil || picked.Name != "b" {
+		t.Errorf("picked %v, want b", picked)
+	}
+}
+
+func TestExtenderTimeout(t *testing.T) {
+	s := stubExtender(t, ExtenderFilterResult{Nodes: []string{"b"}}, ExtenderScoreResult{
+		Scores: map[string]float64{"a": 0, "b": MaxExtenderScore},
+	}, 200*time.Millisecond)
+	base := &Greedy{Name: "greedy"}
+	e := NewExtender(base, s.URL, 20*time.Millisecond, 1)
+	nodes := testNodes()
+	nodes[1].CpuUsage = 0.5
+
+	candidates := e.SelectCandidateNodes(task.Task{}, nodes)
+	if len(candidates) != 2 {
+		t.Errorf("candidates = %v, want all nodes", names(candidates))
+	}
+	scores := e.Score(task.Task{}, nodes)
+	want := base.Score(task.Task{}, nodes)
+	for name, score := range want {
+		if scores[name] != score {
+			t.Errorf("score of %s = %v, want the built-in %v", name, scores[name], score)
+		}
+	}
+}
+
+func TestExtenderFallback(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	erroring := stubExtender(t, ExtenderFilterResult{Error: "boom"}, ExtenderScoreResult{Error: "boom"}, 0)
+
+	for _, url := range []string{failing.URL, erroring.URL} {
+		base := NewBinPack()
+		e := NewExtender(base, url, time.Second, 1)
+		nodes := testNodes()
+
+		candidates := e.SelectCandidateNodes(task.Task{}, nodes)
+		if len(candidates) != 2 || e.Failed != nil {
+			t.Errorf("%s: candidates = %v, failed = %v, want all nodes", url, names(candidates), e.Failed)
+		}
+		scores := e.Score(task.Task{}, nodes)
+		want := base.Score(task.Task{}, nodes)
+		for name, score := range want {
+			if scores[name] != score {
+				t.Errorf("%s: score of %s = %v, want the built-in %v", url, name, scores[name], score)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"sort"
+
+	"cube/node"
+	"cube/task"
+)
+
+// Preempt finds a node the task fits on once some of the tasks there with a
+// lower priority are evicted, and returns it with those tasks. Of the nodes
+// that work, it picks the one where the most important evicted task has the
+// lowest priority, then the one with the fewest evicted tasks. A node is only
+// picked if the scheduler's filters, an extender's included, accept it once
+// the tasks are evicted. It returns a nil node if evicting lower priority
+// tasks doesn't make room anywhere.
+func Preempt(s Scheduler, t task.Task, nodes []*node.Node) (*node.Node, []*task.Task) {
+	type option struct {
+		i       int
+		victims []*task.Task
+	}
+	var options []option
+	for i := range nodes {
+		victims := findVictims(t, i, nodes)
+		if victims != nil {
+			options = append(options, option{i: i, victims: victims})
+		}
+	}
+	sort.SliceStable(options, func(a, b int) bool {
+		return fewerVictims(options[a].victims, options[b].victims)
+	})
+
+	for _, o := range options {
+		if accepts(s, t, o.i, nodes, o.victims) {
+			return nodes[o.i], o.victims
+		}
+	}
+	return nil, nil
+}
+
+// accepts reports whether the scheduler selects nodes[i] as a candidate for
+// the task once the victims are evicted from it.
+func accepts(s Scheduler, t task.Task, i int, nodes []*node.Node, victims []*task.Task) bool {
+	others := make([]*node.Node, len(nodes))
+	copy(others, nodes)
+	others[i] = withoutTasks(nodes[i], victims)
+	for _, n := range s.SelectCandidateNodes(t, others) {
+		if n.Name == nodes[i].Name {
+			return true
+		}
+	}
+	return false
+}
+
+// findVictims returns the tasks to evict from nodes[i] for the task to fit
+// there, or nil if evicting the tasks with a lower priority isn't enough or
+// the task already fits.
+func findVictims(t task.Task, i int, nodes []*node.Node) []*task.Task {
+	n := nodes[i]
+	var lower []*task.Task
+	for _, placed := range n.Tasks {
+		if placed.Priority < t.Priority {
+			lower = append(lower, placed)
+		}
+	}
+	if len(lower) == 0 {
+		return nil
+	}
+	sort.SliceStable(lower, func(a, b int) bool {
+		return lower[a].Priority < lower[b].Priority
+	})
+
+	fits := func(victims []*task.Task) bool {
+		others := make([]*node.Node, len(nodes))
+		copy(others, nodes)
+		others[i] = withoutTasks(n, victims)
+		return CheckNode(t, others[i], others) == ""
+	}
+	if !fits(lower) {
+		return nil
+	}
+
+	// spare the most important tasks that don't have to go
+	victims := lower
+	for j := len(lower) - 1; j >= 0; j-- {
+		remaining := make([]*task.Task, 0, len(victims))
+		for _, v := range victims {
+			if v != lower[j] {
+				remaining = append(remaining, v)
+			}
+		}
+		if fits(remaining) {
+			victims = remaining
+		}
+	}
+	if len(victims) == 0 {
+		return nil
+	}
+	return victims
+}
+
+// withoutTasks returns a copy of the node as it would be with the tasks
+// removed.
+func withoutTasks(n *node.Node, removed []*task.Task) *node.Node {
+	copied := *n
+	copied.Tasks = nil
+	copied.PortsAllocated = nil
+	for _, placed := range n.Tasks {
+		gone := false
+		for _, r := range removed {
+			if r == placed {
+				gone = true
+			}
+		}
+		if gone {
+			copied.CpuAllocated -= placed.Cpu
+			copied.MemoryAllocated -= placed.Memory / 1024
+			copied.DiskAllocated -= placed.Disk
+			continue
+		}
+		copied.Tasks = append(copied.Tasks, placed)
+		if ports, err := task.HostPorts(placed.PortBindings); err == nil {
+			copied.PortsAllocated = append(copied.PortsAllocated, ports...)
+		}
+	}
+	copied.TaskCount = len(copied.Tasks)
+	return &copied
+}
+
+// fewerVictims reports whether evicting a costs less than evicting b.
+func fewerVictims(a []*task.Task, b []*task.Task) bool {
+	if maxPriority(a) != maxPriority(b) {
+		return maxPriority(a) < maxPriority(b)
+	}
+	return len(a) < len(b)
+}
+
+func maxPriority(tasks []*task.Task) int {
+	max := tasks[0].Priority
+	for _, t := range tasks {
+		if t.Priority > max {
+			max = t.Priority
+		}
+	}
+	return max
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"cube/node"
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// fullNodes returns nodes a and b, both full with a task of the given
+// priority.
+func fullNodes(priorityA int, priorityB int) []*node.Node {
+	nodes := testNodes()
+	for i, p := range []int{priorityA, priorityB} {
+		nodes[i].Cpu = 2
+		nodes[i].CpuAllocated = 2
+		nodes[i].Tasks = []*task.Task{{ID: uuid.New(), Cpu: 2, Priority: p}}
+		nodes[i].TaskCount = 1
+	}
+	return nodes
+}
+
+func TestPreempt(t *testing.T) {
+	pending := task.Task{ID: uuid.New(), Cpu: 1, Priority: 10}
+
+	n, victims := Preempt(&Greedy{Name: "greedy"}, pending, fullNodes(1, 5))
+	if n == nil || n.Name != "a" {
+		t.Fatalf("node = %v, want a", n)
+	}
+	if len(victims) != 1 || victims[0].Priority != 1 {
+		t.Errorf("victims = %v, want the task with priority 1", victims)
+	}
+
+	n, _ = Preempt(&Greedy{Name: "greedy"}, pending, fullNodes(20, 20))
+	if n != nil {
+		t.Errorf("node = %v, want none for higher priority tasks", n.Name)
+	}
+}
+
+func TestPreemptSkipsNodesTheExtenderRejects(t *testing.T) {
+	s := stubExtender(t, ExtenderFilterResult{
+		Nodes:       []string{"b"},
+		FailedNodes: map[string]string{"a": "no gpu"},
+	}, ExtenderScoreResult{}, 0)
+	e := NewExtender(&Greedy{Name: "greedy"}, s.URL, time.Second, 1)
+
+	pending := task.Task{ID: uuid.New(), Cpu: 1, Priority: 10}
+	n, victims := Preempt(e, pending, fullNodes(1, 5))
+	if n == nil || n.Name != "b" {
+		t.Fatalf("node = %v, want b", n)
+	}
+	if len(victims) != 1 || victims[0].Priority != 5 {
+		t.Errorf("victims = %v, want the task with priority 5", victims)
+	}
+}
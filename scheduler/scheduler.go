@@ -3,15 +3,59 @@ package scheduler
 import (
 	"cube/node"
 	"cube/task"
+	"fmt"
 	"log"
 	"math"
-	"time"
 )
 
 type Scheduler interface {
 	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
 	Score(t task.Task, nodes []*node.Node) map[string]float64
 	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
+	// HigherIsBetter reports whether Pick prefers nodes with higher scores
+	HigherIsBetter() bool
+}
+
+// Names of the built-in schedulers.
+var Names = []string{"roundrobin", "greedy", "epvm", "binpack"}
+
+// New returns the built-in scheduler with the name.
+func New(name string) (Scheduler, error) {
+	switch name {
+	case "roundrobin":
+		return &RoundRobin{Name: "roundrobin"}, nil
+	case "greedy":
+		return &Greedy{Name: "greedy"}, nil
+	case "epvm":
+		return &Epvm{Name: "epvm"}, nil
+	case "binpack":
+		return NewBinPack(), nil
+	}
+	return nil, fmt.Errorf("unknown scheduler %q", name)
+}
+
+// Copy returns a copy of the scheduler, so that placements can be tried
+// without changing the state of s such as the RoundRobin cursor.
+func Copy(s Scheduler) Scheduler {
+	switch s := s.(type) {
+	case *RoundRobin:
+		c := *s
+		return &c
+	case *Greedy:
+		c := *s
+		return &c
+	case *Epvm:
+		c := *s
+		return &c
+	case *BinPack:
+		c := *s
+		return &c
+	case *Extender:
+		c := *s
+		c.Base = Copy(s.Base)
+		return &c
+	}
+	return s
 }
 
 
@@ -22,7 +66,13 @@ type RoundRobin struct {
 
 
 func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
-	return nodes
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if CheckNode(t, n, nodes) == "" {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
 }
 
 
@@ -43,6 +93,7 @@ func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 			} else {
 				nodeScores[node.Name] = 1.0
 			}
+			nodeScores[node.Name] -= preference(t, node)
     }
 
     return nodeScores
@@ -69,6 +120,10 @@ func (r *RoundRobin) Pick(scores map[string]float64,candidates []*node.Node) *no
 	return bestNode
 }
 
+func (r *RoundRobin) HigherIsBetter() bool {
+	return false
+}
+
 type Greedy struct {
 	Name string
 }
@@ -81,13 +136,8 @@ func (g *Greedy) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 	nodeScores := make(map[string]float64)
 
 	for _, node := range nodes {
-		cpuUsage, err := calculateCpuUsage(node)
-		if err != nil {
-			log.Printf("error calculating CPU usage for node %s, skipping: %v\n", node.Name, err)
-			continue
-		}
-		cpuLoad := calculateLoad(float64(*cpuUsage), math.Pow(2, 0.8))
-		nodeScores[node.Name] = cpuLoad
+		cpuLoad := calculateLoad(node.CpuUsage, math.Pow(2, 0.8))
+		nodeScores[node.Name] = cpuLoad - preference(t, node)
 	}
 	return nodeScores
 }
@@ -110,12 +160,177 @@ func (g *Greedy) Pick(candidates map[string]float64, nodes []*node.Node) *node.N
 	return bestNode
 }
 
+func (g *Greedy) HigherIsBetter() bool {
+	return false
+}
+
+
+// LIEB is the base of the E-PVM cost function. Raising it to a node's
+// utilisation makes every extra unit of load cost more than the one before,
+// so tasks go where they raise the total cost the least.
+const LIEB = 1.53960071783900203869
+
+// maxJobs is the number of tasks at which a node counts as fully loaded in
+// the task count part of the E-PVM cost.
+const maxJobs = 4.0
+
+// Epvm schedules tasks with the Enhanced Parallel Virtual Machine algorithm.
+// A node's score is the marginal cost of placing the task on it, summed over
+// its CPU, its memory and its number of tasks.
+type Epvm struct {
+	Name string
+}
+
+func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(t, nodes)
+}
+
+func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+
+	for _, node := range nodes {
+		cpuRequest := 0.0
+		if node.Cpu > 0 {
+			cpuRequest = t.Cpu / node.Cpu
+		}
+		cpuCost := marginalCost(node.CpuUsage, cpuRequest)
+
+		// task memory is in bytes, node memory in KiB. The memory of a node
+		// whose stats haven't been collected yet is unknown.
+		memoryCost := 0.0
+		if node.Memory > 0 && node.Stats.MemStats != nil {
+			memoryLoad := float64(node.Stats.MemUsedKb()) / float64(node.Memory)
+			memoryRequest := float64(t.Memory) / 1024 / float64(node.Memory)
+			memoryCost = marginalCost(memoryLoad, memoryRequest)
+		}
+
+		taskCost := marginalCost(float64(node.TaskCount)/maxJobs, 1/maxJobs)
+
+		nodeScores[node.Name] = cpuCost + memoryCost + taskCost - preference(t, node)
+	}
+	return nodeScores
+}
+
+func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var bestNode *node.Node
+	var minCost float64
+	for _, node := range candidates {
+		cost, ok := scores[node.Name]
+		if !ok {
+			continue
+		}
+		if bestNode == nil || cost < minCost {
+			minCost = cost
+			bestNode = node
+		}
+	}
+	return bestNode
+}
+
+func (e *Epvm) HigherIsBetter() bool {
+	return false
+}
+
+// BinPack packs tasks onto as few nodes as possible so that idle nodes can
+// be shut down. A node's score is the weighted average of the fraction of
+// its CPU, memory and disk that would be allocated once the task is placed,
+// and the most allocated node is picked.
+type BinPack struct {
+	Name         string
+	CpuWeight    float64
+	MemoryWeight float64
+	DiskWeight   float64
+}
+
+// NewBinPack returns a BinPack scheduler weighting all resources equally.
+func NewBinPack() *BinPack {
+	return &BinPack{Name: "binpack", CpuWeight: 1, MemoryWeight: 1, DiskWeight: 1}
+}
+
+// SetWeights sets the weights of the resources named "cpu", "memory" and
+// "disk" in weights. Resources that aren't named keep their weight.
+func (b *BinPack) SetWeights(weights map[string]float64) error {
+	for resource, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of %s can't be negative", resource)
+		}
+		switch resource {
+		case "cpu":
+			b.CpuWeight = weight
+		case "memory":
+			b.MemoryWeight = weight
+		case "disk":
+			b.DiskWeight = weight
+		default:
+			return fmt.Errorf("unknown resource %q, expected cpu, memory or disk", resource)
+		}
+	}
+	if b.CpuWeight+b.MemoryWeight+b.DiskWeight == 0 {
+		return fmt.Errorf("at least one resource needs a weight")
+	}
+	return nil
+}
+
+func (b *BinPack) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(t, nodes)
+}
+
+func (b *BinPack) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+
+	for _, node := range nodes {
+		var score, weights float64
+		// resources whose capacity isn't known don't count
+		if node.Cpu > 0 {
+			score += b.CpuWeight * (node.CpuAllocated + t.Cpu) / node.Cpu
+			weights += b.CpuWeight
+		}
+		if node.Memory > 0 {
+			// task memory is in bytes, node memory in KiB
+			allocated := float64(node.MemoryAllocated) + float64(t.Memory)/1024
+			score += b.MemoryWeight * allocated / float64(node.Memory)
+			weights += b.MemoryWeight
+		}
+		if node.Disk > 0 {
+			score += b.DiskWeight * float64(node.DiskAllocated+t.Disk) / float64(node.Disk)
+			weights += b.DiskWeight
+		}
+		if weights > 0 {
+			score /= weights
+		}
+		nodeScores[node.Name] = score + preference(t, node)
+	}
+	return nodeScores
+}
+
+// Pick returns the most allocated node. Ties go to the node running the most
+// tasks, so that tasks requesting no resources are packed too.
+func (b *BinPack) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var bestNode *node.Node
+	for _, node := range candidates {
+		if bestNode == nil || scores[node.Name] > scores[bestNode.Name] ||
+			(scores[node.Name] == scores[bestNode.Name] && node.TaskCount > bestNode.TaskCount) {
+			bestNode = node
+		}
+	}
+	return bestNode
+}
+
+func (b *BinPack) HigherIsBetter() bool {
+	return true
+}
+
+// marginalCost is the increase of the E-PVM cost of a resource when its
+// utilisation grows from load by request, both as fractions of capacity.
+func marginalCost(load float64, request float64) float64 {
+	return math.Pow(LIEB, load+request) - math.Pow(LIEB, load)
+}
 
 func selectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
 	var candidates []*node.Node
 	for node := range nodes {
 
-		if checkDisk(t, nodes[node].Disk-nodes[node].DiskAllocated) {
+		if CheckNode(t, nodes[node], nodes) == "" {
 			candidates = append(candidates, nodes[node])
 		}
 
@@ -124,43 +339,84 @@ func selectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
 	return candidates
 }
 
-func checkDisk(t task.Task, diskAvailable int64) bool {
-	return t.Disk <= diskAvailable
-}
-
-func calculateLoad(usage float64, capacity float64) float64 {
-	return usage / capacity
-}
-
+// preference is the share, from 0 to 1, of the weight of the task's preferred
+// affinity rules that the node matches, less the share of the node's
+// PreferNoSchedule taints the task doesn't tolerate, and less 1 if the
+// node's stats are stale. Schedulers picking the lowest score subtract it
+// from the node's score, the binpack scheduler adds it, so that a preferred
+// node wins over one that is only slightly better otherwise.
+func preference(t task.Task, n *node.Node) float64 {
+	p := t.Affinity.Preference(n.Labels)
+	if n.StatsStale() {
+		p--
+	}
 
-func calculateCpuUsage(node *node.Node) (*float64, error) {
-	stat1, err := node.GetStats()
-	if err != nil {
-		return nil, err
+	prefer := 0
+	for _, taint := range n.Taints {
+		if taint.Effect == node.PreferNoSchedule {
+			prefer++
+		}
 	}
-	time.Sleep(3 * time.Second)
-	stat2, err := node.GetStats()
-	if err != nil {
-		return nil, err
+	if prefer > 0 {
+		p -= float64(len(n.Untolerated(t, node.PreferNoSchedule))) / float64(prefer)
 	}
+	return p
+}
 
-	stat1Idle := stat1.CpuStats.Idle + stat1.CpuStats.IOWait
-	stat2Idle := stat2.CpuStats.Idle + stat2.CpuStats.IOWait
+// CheckNode returns why the task can't be placed on the node, or an empty
+// string if it fits. A node whose capacity of a resource isn't known yet
+// isn't filtered on that resource. Task and node disk are both in bytes;
+// task memory is in bytes and node memory in KiB. The other nodes are needed
+// to place the task relative to the tasks on them.
+func CheckNode(t task.Task, n *node.Node, nodes []*node.Node) string {
+	switch {
+	case n.Unschedulable:
+		return "node is cordoned"
+	case len(n.Untolerated(t, node.NoSchedule)) > 0 || len(n.Untolerated(t, node.NoExecute)) > 0:
+		return "untolerated taint"
+	case !t.MatchesNodeSelector(n.Labels):
+		return "node selector doesn't match"
+	case !t.MatchesAffinity(n.Labels):
+		return "node affinity doesn't match"
+	case n.Cpu > 0 && !checkCpu(t, n.Cpu-n.CpuAllocated):
+		return "insufficient cpu"
+	case n.Memory > 0 && !checkMemory(t, n.Memory-n.MemoryAllocated):
+		return "insufficient memory"
+	case n.Disk > 0 && !checkDisk(t, n.Disk-n.DiskAllocated):
+		return "insufficient disk"
+	case !checkPorts(t, n):
+		return "host ports in use"
+	case !checkAntiAffinity(t, n, nodes):
+		return "anti-affinity conflict"
+	}
+	return checkSpread(t, n, nodes)
+}
 
-	stat1NonIdle := stat1.CpuStats.User + stat1.CpuStats.Nice + stat1.CpuStats.System + stat1.CpuStats.IRQ + stat1.CpuStats.SoftIRQ + stat1.CpuStats.Steal
-	stat2NonIdle := stat2.CpuStats.User + stat2.CpuStats.Nice + stat2.CpuStats.System + stat2.CpuStats.IRQ + stat2.CpuStats.SoftIRQ + stat2.CpuStats.Steal
+func checkCpu(t task.Task, cpuAvailable float64) bool {
+	return t.Cpu <= cpuAvailable
+}
 
-	stat1Total := stat1Idle + stat1NonIdle
-	stat2Total := stat2Idle + stat2NonIdle
+// checkMemory compares the task's request in bytes with the memory
+// available on the node in KiB.
+func checkMemory(t task.Task, memoryAvailable int64) bool {
+	return t.Memory/1024 <= memoryAvailable
+}
 
-	total := stat2Total - stat1Total
-	idle := stat2Idle - stat1Idle
+func checkDisk(t task.Task, diskAvailable int64) bool {
+	return t.Disk <= diskAvailable
+}
 
-	var cpuPercentUsage float64
-	if total == 0 && idle == 0 {
-		cpuPercentUsage = 0.00
-	} else {
-		cpuPercentUsage = (float64(total) - float64(idle)) / float64(total)
+// checkPorts reports whether every fixed host port the task binds is free
+// on the node.
+func checkPorts(t task.Task, n *node.Node) bool {
+	ports, err := task.HostPorts(t.PortBindings)
+	if err != nil {
+		log.Printf("invalid port bindings for task %s: %v\n", t.ID, err)
+		return false
 	}
-	return &cpuPercentUsage, nil
-}
\ No newline at end of file
+	return task.PortConflict(ports, n.PortsAllocated) == nil
+}
+
+func calculateLoad(usage float64, capacity float64) float64 {
+	return usage / capacity
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"cube/node"
+	"cube/task"
+)
+
+// topologyDomain returns the value of the node's key label, or the node's
+// name if key is empty. It returns false if the node doesn't have the label.
+func topologyDomain(n *node.Node, key string) (string, bool) {
+	if key == "" {
+		return n.Name, true
+	}
+	value, ok := n.Labels[key]
+	return value, ok
+}
+
+// countMatching counts the tasks on the node, other than t itself, whose
+// labels match the selector.
+func countMatching(t task.Task, n *node.Node, selector []task.LabelExpression) int {
+	count := 0
+	for _, placed := range n.Tasks {
+		if placed.ID != t.ID && task.MatchesSelector(selector, placed.Labels) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkAntiAffinity reports whether placing the task on n keeps it out of the
+// topology domains of the tasks its anti-affinity rules match.
+func checkAntiAffinity(t task.Task, n *node.Node, nodes []*node.Node) bool {
+	for _, rule := range t.AntiAffinity {
+		domain, ok := topologyDomain(n, rule.TopologyKey)
+		if !ok {
+			continue
+		}
+		for _, other := range nodes {
+			if d, ok := topologyDomain(other, rule.TopologyKey); !ok || d != domain {
+				continue
+			}
+			if countMatching(t, other, rule.LabelSelector) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkSpread returns why placing the task on n would break one of its
+// topology spread constraints, or an empty string if it wouldn't. The
+// domains are those of the schedulable nodes the task's node selector and
+// affinity allow.
+func checkSpread(t task.Task, n *node.Node, nodes []*node.Node) string {
+	for _, c := range t.TopologySpread {
+		domain, ok := topologyDomain(n, c.TopologyKey)
+		if !ok {
+			return "missing topology label"
+		}
+
+		counts := make(map[string]int)
+		for _, other := range nodes {
+			if other != n && other.Unschedulable {
+				continue
+			}
+			if !t.MatchesNodeSelector(other.Labels) || !t.MatchesAffinity(other.Labels) {
+				continue
+			}
+			d, ok := topologyDomain(other, c.TopologyKey)
+			if !ok {
+				continue
+			}
+			counts[d] += countMatching(t, other, c.LabelSelector)
+		}
+
+		min := -1
+		for _, count := range counts {
+			if min < 0 || count < min {
+				min = count
+			}
+		}
+		count := counts[domain]
+		if task.MatchesSelector(c.LabelSelector, t.Labels) {
+			count++
+		}
+		if count-min > c.MaxSkew {
+			return "max skew exceeded"
+		}
+	}
+	return ""
+}
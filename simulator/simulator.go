@@ -0,0 +1,334 @@
+package simulator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"cube/node"
+	"cube/scheduler"
+	"cube/task"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/google/uuid"
+)
+
+// NodeSpec describes Count identical nodes of a simulated cluster. Memory
+// and Disk are in bytes, like the requests of tasks.
+type NodeSpec struct {
+	Name string
+	// Count of nodes, named Name-1, Name-2... when more than one
+	Count  int
+	Cpu    float64
+	Memory int64
+	Disk   int64
+	Labels map[string]string
+	Taints []node.Taint
+}
+
+// Cluster is the description of a simulated cluster.
+type Cluster struct {
+	Nodes []NodeSpec
+}
+
+// Submission is a task submitted Time seconds after the start of a trace,
+// which runs for Duration seconds once placed, or until the end if zero.
+type Submission struct {
+	Time     float64
+	Duration float64
+	Task     task.Task
+}
+
+// Report sums up how a scheduler placed a trace. Times are in seconds, and
+// utilisation and fragmentation are averaged over the simulated time.
+type Report struct {
+	Tasks  int
+	Placed int
+	// Unschedulable tasks were still pending at the end of the trace
+	Unschedulable int
+	AvgPending    float64
+	MaxPending    float64
+	// CpuUtilisation and MemoryUtilisation are the shares of the cluster's
+	// capacity allocated to tasks
+	CpuUtilisation    float64
+	MemoryUtilisation float64
+	// Fragmentation is the share of the free CPU and memory that isn't on
+	// the node with the most free, so can't be used by one large task
+	Fragmentation float64
+}
+
+// LoadCluster reads a cluster description from a JSON file.
+func LoadCluster(path string) (*Cluster, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Cluster{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error decoding cluster %s: %v", path, err)
+	}
+	if len(c.Nodes) == 0 {
+		return nil, fmt.Errorf("cluster %s has no nodes", path)
+	}
+	for _, spec := range c.Nodes {
+		if spec.Name == "" || spec.Cpu <= 0 || spec.Memory <= 0 {
+			return nil, fmt.Errorf("nodes of cluster %s need a name, cpu and memory", path)
+		}
+		for _, taint := range spec.Taints {
+			if err := taint.Validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &c, nil
+}
+
+// LoadTrace reads task submissions from a JSONL file, one Submission per
+// line.
+func LoadTrace(path string) ([]Submission, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var trace []Submission
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		s := Submission{}
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("error decoding line %d of trace %s: %v", line, path, err)
+		}
+		if err := task.ValidatePlacement(&s.Task); err != nil {
+			return nil, fmt.Errorf("invalid task on line %d of trace %s: %v", line, path, err)
+		}
+		trace = append(trace, s)
+	}
+	return trace, scanner.Err()
+}
+
+// placement is a task running on a simulated node.
+type placement struct {
+	task *task.Task
+	node *node.Node
+	end  float64
+}
+
+type waiting struct {
+	task      *task.Task
+	submitted float64
+	duration  float64
+	seq       int
+}
+
+// Run plays the trace against the cluster with the scheduler and reports how
+// it placed the tasks. Tasks are placed whenever a task is submitted or
+// finishes, the pending ones in order of priority then submission, as the
+// manager does. The nodes' stats are simulated from what the tasks on them
+// request.
+func Run(c *Cluster, trace []Submission, s scheduler.Scheduler) Report {
+	nodes := c.build()
+	submissions := make([]Submission, len(trace))
+	copy(submissions, trace)
+	sort.SliceStable(submissions, func(i, j int) bool {
+		return submissions[i].Time < submissions[j].Time
+	})
+
+	report := Report{Tasks: len(trace)}
+	var pending []*waiting
+	var running []placement
+	var pendingTotal, cpuArea, memoryArea, fragArea, now float64
+	next := 0
+
+	var cpuCapacity, memoryCapacity float64
+	for _, n := range nodes {
+		cpuCapacity += n.Cpu
+		memoryCapacity += float64(n.Memory)
+	}
+
+	for {
+		// the next event is a submission or a task finishing
+		at := math.Inf(1)
+		if next < len(submissions) {
+			at = submissions[next].Time
+		}
+		for _, p := range running {
+			if p.end > 0 && p.end < at {
+				at = p.end
+			}
+		}
+		if math.IsInf(at, 1) {
+			break
+		}
+
+		if at > now {
+			elapsed := at - now
+			cpu, memory, frag := usage(nodes)
+			cpuArea += cpu * elapsed
+			memoryArea += memory * elapsed
+			fragArea += frag * elapsed
+			now = at
+		}
+
+		var still []placement
+		for _, p := range running {
+			if p.end > 0 && p.end <= now {
+				remove(p.node, p.task)
+				continue
+			}
+			still = append(still, p)
+		}
+		running = still
+
+		for next < len(submissions) && submissions[next].Time <= now {
+			sub := submissions[next]
+			t := sub.Task
+			if t.ID == uuid.Nil {
+				t.ID = uuid.New()
+			}
+			pending = append(pending, &waiting{task: &t, submitted: sub.Time, duration: sub.Duration, seq: next})
+			next++
+		}
+
+		sort.SliceStable(pending, func(i, j int) bool {
+			if pending[i].task.Priority != pending[j].task.Priority {
+				return pending[i].task.Priority > pending[j].task.Priority
+			}
+			return pending[i].seq < pending[j].seq
+		})
+		var unplaced []*waiting
+		for _, w := range pending {
+			n := place(s, *w.task, nodes)
+			if n == nil {
+				unplaced = append(unplaced, w)
+				continue
+			}
+
+			w.task.State = task.Running
+			n.SetTasks(append(n.Tasks, w.task))
+			refresh(n)
+			p := placement{task: w.task, node: n}
+			if w.duration > 0 {
+				p.end = now + w.duration
+			}
+			running = append(running, p)
+
+			waited := now - w.submitted
+			pendingTotal += waited
+			report.MaxPending = math.Max(report.MaxPending, waited)
+			report.Placed++
+		}
+		pending = unplaced
+	}
+
+	report.Unschedulable = len(pending)
+	if report.Placed > 0 {
+		report.AvgPending = pendingTotal / float64(report.Placed)
+	}
+	if now > 0 {
+		report.CpuUtilisation = cpuArea / now / cpuCapacity
+		report.MemoryUtilisation = memoryArea / now / memoryCapacity
+		report.Fragmentation = fragArea / now
+	}
+	return report
+}
+
+// build creates the nodes of the cluster, with their stats as if they were
+// idle.
+func (c *Cluster) build() []*node.Node {
+	var nodes []*node.Node
+	for _, spec := range c.Nodes {
+		count := spec.Count
+		if count < 1 {
+			count = 1
+		}
+		for i := 1; i <= count; i++ {
+			nodeName := spec.Name
+			if count > 1 {
+				nodeName = fmt.Sprintf("%s-%d", spec.Name, i)
+			}
+			n := node.NewNode(nodeName, "", "worker")
+			n.Cpu = spec.Cpu
+			n.Memory = spec.Memory / 1024
+			n.Disk = spec.Disk
+			n.Labels = spec.Labels
+			n.Taints = spec.Taints
+			refresh(n)
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// place runs the scheduler for the task and returns the node it picks.
+func place(s scheduler.Scheduler, t task.Task, nodes []*node.Node) *node.Node {
+	candidates := s.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		return nil
+	}
+	scores := s.Score(t, candidates)
+	return s.Pick(scores, candidates)
+}
+
+func remove(n *node.Node, t *task.Task) {
+	var tasks []*task.Task
+	for _, placed := range n.Tasks {
+		if placed != t {
+			tasks = append(tasks, placed)
+		}
+	}
+	n.SetTasks(tasks)
+	refresh(n)
+}
+
+// refresh simulates the stats of the node, assuming its tasks use what they
+// request.
+func refresh(n *node.Node) {
+	n.CpuUsage = math.Min(n.CpuAllocated/n.Cpu, 1)
+	used := n.MemoryAllocated
+	if used > n.Memory {
+		used = n.Memory
+	}
+	n.Stats.MemStats = &linux.MemInfo{
+		MemTotal:     uint64(n.Memory),
+		MemAvailable: uint64(n.Memory - used),
+	}
+	n.StatsUpdated = time.Now().UTC()
+}
+
+// usage returns the CPU and memory allocated on the nodes and the
+// fragmentation of what is free.
+func usage(nodes []*node.Node) (float64, float64, float64) {
+	var cpu, memory float64
+	var cpuFree, cpuMaxFree, memoryFree, memoryMaxFree float64
+	for _, n := range nodes {
+		cpu += n.CpuAllocated
+		memory += float64(n.MemoryAllocated)
+
+		free := math.Max(n.Cpu-n.CpuAllocated, 0)
+		cpuFree += free
+		cpuMaxFree = math.Max(cpuMaxFree, free)
+		free = math.Max(float64(n.Memory-n.MemoryAllocated), 0)
+		memoryFree += free
+		memoryMaxFree = math.Max(memoryMaxFree, free)
+	}
+
+	var frag float64
+	if cpuFree > 0 {
+		frag += (1 - cpuMaxFree/cpuFree) / 2
+	}
+	if memoryFree > 0 {
+		frag += (1 - memoryMaxFree/memoryFree) / 2
+	}
+	return cpu, memory, frag
+}
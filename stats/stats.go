@@ -2,6 +2,7 @@ package stats
 
 import (
 	"log"
+	"runtime"
 
 	"github.com/c9s/goprocinfo/linux"
 )
@@ -11,6 +12,7 @@ type Stats struct {
     DiskStats *linux.Disk
     CpuStats  *linux.CPUStat
     LoadStats *linux.LoadAvg
+    CpuCount  int
 	TaskCount int
 }
 
@@ -56,12 +58,30 @@ func (s*Stats) CpuUsage() float64 {
 	return (float64(total) - float64(nonidle))/float64(total)
 }
 
+// CpuUsageBetween returns the share of CPU time that was busy between two
+// samples of the stats, from 0 to 1.
+func CpuUsageBetween(prev *Stats, cur *Stats) float64 {
+	prevIdle := prev.CpuStats.Idle + prev.CpuStats.IOWait
+	curIdle := cur.CpuStats.Idle + cur.CpuStats.IOWait
+
+	prevNonIdle := prev.CpuStats.User + prev.CpuStats.Nice + prev.CpuStats.System + prev.CpuStats.IRQ + prev.CpuStats.SoftIRQ + prev.CpuStats.Steal
+	curNonIdle := cur.CpuStats.User + cur.CpuStats.Nice + cur.CpuStats.System + cur.CpuStats.IRQ + cur.CpuStats.SoftIRQ + cur.CpuStats.Steal
+
+	total := (curIdle + curNonIdle) - (prevIdle + prevNonIdle)
+	idle := curIdle - prevIdle
+	if total == 0 {
+		return 0.00
+	}
+	return (float64(total) - float64(idle)) / float64(total)
+}
+
 func GetStats() *Stats {
 	return &Stats{
 		MemStats: GetMemoryInfo(),
 		DiskStats: GetDiskInfo(),
 		CpuStats: GetCpuStats(),
 		LoadStats: GetLoadAvg(),
+		CpuCount: runtime.NumCPU(),
 	}
 }
 
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/boltdb/bolt"
+
+	"cube/task"
+)
+
+type InMemoryCronTaskStore struct {
+	Db map[string]*task.CronTask
+}
+
+func NewInMemoryCronTaskStore() *InMemoryCronTaskStore {
+	return &InMemoryCronTaskStore{
+		Db: make(map[string]*task.CronTask),
+	}
+}
+
+func (i *InMemoryCronTaskStore) Put(key string, value interface{}) error {
+	c, ok := value.(*task.CronTask)
+	if !ok {
+		return fmt.Errorf("value %v is not a task.CronTask type", value)
+	}
+	i.Db[key] = c
+	return nil
+}
+
+func (i *InMemoryCronTaskStore) Get(key string) (interface{}, error) {
+	c, ok := i.Db[key]
+	if !ok {
+		return nil, fmt.Errorf("cron task with key %s does not exist", key)
+	}
+
+	return c, nil
+}
+
+func (i *InMemoryCronTaskStore) List() (interface{}, error) {
+	var crons []*task.CronTask
+	for _, c := range i.Db {
+		crons = append(crons, c)
+	}
+	return crons, nil
+}
+
+func (i *InMemoryCronTaskStore) Count() (int, error) {
+	return len(i.Db), nil
+}
+
+func (i *InMemoryCronTaskStore) Delete(key string) error {
+	delete(i.Db, key)
+	return nil
+}
+
+type CronTaskStore struct {
+	Db       *bolt.DB
+	DbFile   string
+	FileMode os.FileMode
+	Bucket   string
+}
+
+func (c *CronTaskStore) CreateBucket() error {
+	return c.Db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(c.Bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket %s: %s", c.Bucket, err)
+		}
+		return nil
+	})
+}
+
+func (c *CronTaskStore) Close() {
+	c.Db.Close()
+}
+
+func (c *CronTaskStore) Count() (int, error) {
+	cronCount := 0
+	err := c.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.Bucket))
+		b.ForEach(func(k, v []byte) error {
+			cronCount++
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return cronCount, nil
+}
+
+func (c *CronTaskStore) Put(key string, value interface{}) error {
+	return c.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.Bucket))
+
+		buf, err := json.Marshal(value.(*task.CronTask))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), buf)
+	})
+}
+
+func (c *CronTaskStore) Get(key string) (interface{}, error) {
+	var cron task.CronTask
+	err := c.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.Bucket))
+		v := b.Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("cron task %v not found", key)
+		}
+		return json.Unmarshal(v, &cron)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cron, nil
+}
+
+func (c *CronTaskStore) List() (interface{}, error) {
+	var crons []*task.CronTask
+	err := c.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.Bucket))
+		return b.ForEach(func(k, v []byte) error {
+			var cron task.CronTask
+			err := json.Unmarshal(v, &cron)
+			if err != nil {
+				return err
+			}
+			crons = append(crons, &cron)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return crons, nil
+}
+
+func (c *CronTaskStore) Delete(key string) error {
+	return c.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.Bucket))
+		return b.Delete([]byte(key))
+	})
+}
+
+func NewCronTaskStore(file string, mode os.FileMode, bucket string) (*CronTaskStore, error) {
+	db, err := bolt.Open(file, mode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v", file)
+	}
+	c := CronTaskStore{
+		DbFile:   file,
+		FileMode: mode,
+		Db:       db,
+		Bucket:   bucket,
+	}
+
+	err = c.CreateBucket()
+	if err != nil {
+		log.Printf("bucket already exists, will use it instead of creating new one")
+	}
+
+	return &c, nil
+}
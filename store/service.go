@@ -0,0 +1,172 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/boltdb/bolt"
+
+	"cube/task"
+)
+
+type InMemoryServiceStore struct {
+	Db map[string]*task.Service
+}
+
+func NewInMemoryServiceStore() *InMemoryServiceStore {
+	return &InMemoryServiceStore{
+		Db: make(map[string]*task.Service),
+	}
+}
+
+func (i *InMemoryServiceStore) Put(key string, value interface{}) error {
+	s, ok := value.(*task.Service)
+	if !ok {
+		return fmt.Errorf("value %v is not a task.Service type", value)
+	}
+	i.Db[key] = s
+	return nil
+}
+
+func (i *InMemoryServiceStore) Get(key string) (interface{}, error) {
+	s, ok := i.Db[key]
+	if !ok {
+		return nil, fmt.Errorf("service with key %s does not exist", key)
+	}
+
+	return s, nil
+}
+
+func (i *InMemoryServiceStore) List() (interface{}, error) {
+	var services []*task.Service
+	for _, s := range i.Db {
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+func (i *InMemoryServiceStore) Count() (int, error) {
+	return len(i.Db), nil
+}
+
+func (i *InMemoryServiceStore) Delete(key string) error {
+	delete(i.Db, key)
+	return nil
+}
+
+type ServiceStore struct {
+	Db       *bolt.DB
+	DbFile   string
+	FileMode os.FileMode
+	Bucket   string
+}
+
+func (s *ServiceStore) CreateBucket() error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(s.Bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket %s: %s", s.Bucket, err)
+		}
+		return nil
+	})
+}
+
+func (s *ServiceStore) Close() {
+	s.Db.Close()
+}
+
+func (s *ServiceStore) Count() (int, error) {
+	serviceCount := 0
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		b.ForEach(func(k, v []byte) error {
+			serviceCount++
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return serviceCount, nil
+}
+
+func (s *ServiceStore) Put(key string, value interface{}) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+
+		buf, err := json.Marshal(value.(*task.Service))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), buf)
+	})
+}
+
+func (s *ServiceStore) Get(key string) (interface{}, error) {
+	var service task.Service
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		v := b.Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("service %v not found", key)
+		}
+		return json.Unmarshal(v, &service)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+func (s *ServiceStore) List() (interface{}, error) {
+	var services []*task.Service
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		return b.ForEach(func(k, v []byte) error {
+			var service task.Service
+			err := json.Unmarshal(v, &service)
+			if err != nil {
+				return err
+			}
+			services = append(services, &service)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return services, nil
+}
+
+func (s *ServiceStore) Delete(key string) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		return b.Delete([]byte(key))
+	})
+}
+
+func NewServiceStore(file string, mode os.FileMode, bucket string) (*ServiceStore, error) {
+	db, err := bolt.Open(file, mode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v", file)
+	}
+	s := ServiceStore{
+		DbFile:   file,
+		FileMode: mode,
+		Db:       db,
+		Bucket:   bucket,
+	}
+
+	err = s.CreateBucket()
+	if err != nil {
+		log.Printf("bucket already exists, will use it instead of creating new one")
+	}
+
+	return &s, nil
+}
@@ -16,6 +16,7 @@ type Store interface {
 	Get(key string) (interface{}, error)
 	List() (interface{}, error)
 	Count() (int, error)
+	Delete(key string) error
 }
 
 
@@ -61,6 +62,11 @@ func (i *InMemoryTaskStore) Count() (int, error) {
 	return len(i.Db), nil
 }
 
+func (i *InMemoryTaskStore) Delete(key string) error {
+	delete(i.Db, key)
+	return nil
+}
+
 type InMemoryTaskEventStore struct {
 	Db map[string]*task.TaskEvent
 }
@@ -101,6 +107,11 @@ func (i *InMemoryTaskEventStore) Count() (int, error) {
 	return len(i.Db), nil
 }
 
+func (i *InMemoryTaskEventStore) Delete(key string) error {
+	delete(i.Db, key)
+	return nil
+}
+
 
 type TaskStore struct {
 	Db       *bolt.DB
@@ -202,6 +213,14 @@ func (t *TaskStore) List() (interface{}, error) {
 }
 
 
+func (t *TaskStore) Delete(key string) error {
+	return t.Db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(t.Bucket))
+			return b.Delete([]byte(key))
+	})
+}
+
+
 func NewTaskStore(file string, mode os.FileMode, bucket string) (*TaskStore, error) {
     db, err := bolt.Open(file, mode, nil)
     if err != nil {
@@ -323,6 +342,14 @@ func (e *EventStore) List() (interface{}, error) {
 }
 
 
+func (e *EventStore) Delete(key string) error {
+	return e.Db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(e.Bucket))
+			return b.Delete([]byte(key))
+	})
+}
+
+
 func NewEventStore(file string, mode os.FileMode, bucket string) (*EventStore, error) {
     db, err := bolt.Open(file, mode, nil)
     if err != nil {
@@ -0,0 +1,266 @@
+package task
+
+import (
+	"fmt"
+)
+
+// Operators of a LabelExpression.
+const (
+	OpIn     = "In"
+	OpNotIn  = "NotIn"
+	OpExists = "Exists"
+)
+
+// LabelExpression matches the labels of a node. In matches nodes whose label
+// Key has one of Values, NotIn nodes where it has none of them or is not set,
+// and Exists nodes where it is set to any value.
+type LabelExpression struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// PreferredExpression is an affinity rule the scheduler tries to honour.
+// Nodes matching rules with a higher Weight are preferred.
+type PreferredExpression struct {
+	Weight     int
+	Expression LabelExpression
+}
+
+// Affinity constrains the nodes a task is placed on by their labels. All
+// Required expressions must match for the task to be placed on a node, the
+// Preferred ones only raise the node's chances.
+type Affinity struct {
+	Required  []LabelExpression
+	Preferred []PreferredExpression
+}
+
+// AntiAffinity keeps a task away from the tasks whose labels match all the
+// expressions of LabelSelector. The task isn't placed on a node whose
+// TopologyKey label has the same value as the node of such a task, or on the
+// same node as one if TopologyKey is empty.
+type AntiAffinity struct {
+	LabelSelector []LabelExpression
+	TopologyKey   string
+}
+
+// TopologySpread spreads the tasks whose labels match LabelSelector over the
+// values of the TopologyKey label of the nodes, or over the nodes themselves
+// if TopologyKey is empty. Placing a task mustn't leave more than MaxSkew
+// more matching tasks in its domain than in the domain with the fewest.
+type TopologySpread struct {
+	TopologyKey   string
+	MaxSkew       int
+	LabelSelector []LabelExpression
+}
+
+func (e LabelExpression) Validate() error {
+	if e.Key == "" {
+		return fmt.Errorf("label expression key is required")
+	}
+	switch e.Operator {
+	case OpIn, OpNotIn:
+		if len(e.Values) == 0 {
+			return fmt.Errorf("%s expression on %s needs values", e.Operator, e.Key)
+		}
+	case OpExists:
+		if len(e.Values) > 0 {
+			return fmt.Errorf("Exists expression on %s takes no values", e.Key)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q in expression on %s", e.Operator, e.Key)
+	}
+	return nil
+}
+
+// Matches reports whether a node with the labels matches the expression.
+func (e LabelExpression) Matches(labels map[string]string) bool {
+	value, ok := labels[e.Key]
+	switch e.Operator {
+	case OpIn:
+		return ok && contains(e.Values, value)
+	case OpNotIn:
+		return !ok || !contains(e.Values, value)
+	case OpExists:
+		return ok
+	}
+	return false
+}
+
+func (a *Affinity) Validate() error {
+	if a == nil {
+		return nil
+	}
+	for _, e := range a.Required {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, p := range a.Preferred {
+		if p.Weight <= 0 {
+			return fmt.Errorf("weight of preferred expression on %s must be positive", p.Expression.Key)
+		}
+		if err := p.Expression.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Preference returns the share of the preferred expressions' weight matched
+// by a node with the labels, from 0 to 1.
+func (a *Affinity) Preference(labels map[string]string) float64 {
+	if a == nil {
+		return 0
+	}
+	var matched, total int
+	for _, p := range a.Preferred {
+		total += p.Weight
+		if p.Expression.Matches(labels) {
+			matched += p.Weight
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
+
+// ValidatePlacement checks the labels, node selector, affinity,
+// anti-affinity, topology spread and tolerations of a task.
+func ValidatePlacement(t *Task) error {
+	for k := range t.Labels {
+		if k == "" {
+			return fmt.Errorf("label keys must not be empty")
+		}
+	}
+	for k := range t.NodeSelector {
+		if k == "" {
+			return fmt.Errorf("node selector keys must not be empty")
+		}
+	}
+	for _, a := range t.AntiAffinity {
+		if err := validateSelector(a.LabelSelector); err != nil {
+			return fmt.Errorf("anti-affinity: %v", err)
+		}
+	}
+	for _, c := range t.TopologySpread {
+		if c.MaxSkew < 1 {
+			return fmt.Errorf("topology spread on %q: max skew must be at least 1", c.TopologyKey)
+		}
+		if err := validateSelector(c.LabelSelector); err != nil {
+			return fmt.Errorf("topology spread on %q: %v", c.TopologyKey, err)
+		}
+	}
+	for _, tol := range t.Tolerations {
+		if err := tol.Validate(); err != nil {
+			return err
+		}
+	}
+	return t.Affinity.Validate()
+}
+
+func validateSelector(selector []LabelExpression) error {
+	if len(selector) == 0 {
+		return fmt.Errorf("label selector is required")
+	}
+	for _, e := range selector {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchesSelector reports whether the labels match all the expressions of
+// the selector.
+func MatchesSelector(selector []LabelExpression, labels map[string]string) bool {
+	for _, e := range selector {
+		if !e.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesNodeSelector reports whether a node with the labels has every label
+// of the task's node selector.
+func (t *Task) MatchesNodeSelector(labels map[string]string) bool {
+	for k, v := range t.NodeSelector {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesAffinity reports whether a node with the labels matches all the
+// required affinity expressions of the task.
+func (t *Task) MatchesAffinity(labels map[string]string) bool {
+	if t.Affinity == nil {
+		return true
+	}
+	return MatchesSelector(t.Affinity.Required, labels)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Operators of a Toleration.
+const (
+	TolerationEqual  = "Equal"
+	TolerationExists = "Exists"
+)
+
+// Toleration lets a task be placed on, and keep running on, nodes with a
+// matching taint. With the Equal operator, the default, the taint's key and
+// value must be Key and Value; with Exists any value matches, and an empty
+// Key matches every taint. An empty Effect matches all effects.
+type Toleration struct {
+	Key      string
+	Operator string
+	Value    string
+	Effect   string
+}
+
+func (t Toleration) Validate() error {
+	switch t.Operator {
+	case "", TolerationEqual:
+		if t.Key == "" {
+			return fmt.Errorf("toleration key is required with the Equal operator")
+		}
+	case TolerationExists:
+		if t.Value != "" {
+			return fmt.Errorf("Exists toleration on %s takes no value", t.Key)
+		}
+	default:
+		return fmt.Errorf("unknown toleration operator %q", t.Operator)
+	}
+	return nil
+}
+
+// Tolerates reports whether one of the task's tolerations matches a taint
+// with the key, value and effect.
+func (t *Task) Tolerates(key string, value string, effect string) bool {
+	for _, tol := range t.Tolerations {
+		if tol.Effect != "" && tol.Effect != effect {
+			continue
+		}
+		if tol.Operator == TolerationExists {
+			if tol.Key == "" || tol.Key == key {
+				return true
+			}
+			continue
+		}
+		if tol.Key == key && tol.Value == value {
+			return true
+		}
+	}
+	return false
+}
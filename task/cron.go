@@ -0,0 +1,92 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// Concurrency policies of a CronTask decide what happens when a run is due
+// while the previous one is still active.
+const (
+	// ConcurrencyAllow starts the new run alongside the active ones
+	ConcurrencyAllow = "Allow"
+	// ConcurrencyForbid skips the new run
+	ConcurrencyForbid = "Forbid"
+	// ConcurrencyReplace stops the active runs and starts the new one
+	ConcurrencyReplace = "Replace"
+)
+
+// CronTask starts a copy of Task every time Schedule fires.
+type CronTask struct {
+	ID   uuid.UUID
+	Name string
+	// Schedule is a standard five field cron expression or a descriptor
+	// such as @hourly
+	Schedule string
+	// TimeZone the schedule is evaluated in, UTC if empty
+	TimeZone          string
+	ConcurrencyPolicy string
+	// SuccessfulHistoryLimit and FailedHistoryLimit are the number of
+	// finished runs kept, older runs are deleted. Unset limits get the
+	// manager's defaults, a limit of 0 keeps no runs
+	SuccessfulHistoryLimit *int
+	FailedHistoryLimit     *int
+	Task                   Task
+	LastScheduleTime       time.Time
+	CreatedTime            time.Time
+	// Active, Successful and Failed hold the IDs of the runs, oldest first
+	Active     []uuid.UUID
+	Successful []uuid.UUID
+	Failed     []uuid.UUID
+}
+
+func (c *CronTask) Validate() error {
+	if _, err := c.location(); err != nil {
+		return err
+	}
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %v", c.Schedule, err)
+	}
+	switch c.ConcurrencyPolicy {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("unknown concurrency policy %q", c.ConcurrencyPolicy)
+	}
+	if (c.SuccessfulHistoryLimit != nil && *c.SuccessfulHistoryLimit < 0) || (c.FailedHistoryLimit != nil && *c.FailedHistoryLimit < 0) {
+		return fmt.Errorf("history limits must not be negative")
+	}
+	return ValidatePlacement(&c.Task)
+}
+
+// Next returns the first time the schedule fires after the last run, or
+// after the cron task was created if it has never run.
+func (c *CronTask) Next() (time.Time, error) {
+	loc, err := c.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	schedule, err := cron.ParseStandard(c.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	last := c.LastScheduleTime
+	if last.IsZero() {
+		last = c.CreatedTime
+	}
+	return schedule.Next(last.In(loc)), nil
+}
+
+func (c *CronTask) location() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", c.TimeZone, err)
+	}
+	return loc, nil
+}
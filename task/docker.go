@@ -0,0 +1,315 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/moby/moby/pkg/stdcopy"
+)
+
+type Docker struct {
+	Client *client.Client
+}
+
+func NewDocker() (*Docker, error) {
+	dc, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &Docker{
+		Client: dc,
+	}, nil
+}
+
+func (docker *Docker) Run(c *ContainerConfig) RuntimeResult {
+	ctx := context.Background()
+	reader, err := docker.Client.ImagePull(ctx, c.Image, image.PullOptions{})
+	if err != nil {
+		log.Printf("Error pulling image %s: %v\n", c.Image, err)
+		return RuntimeResult{Error: err}
+	}
+	io.Copy(os.Stdout, reader)
+
+	restartPolicy := container.RestartPolicy{
+		Name: container.RestartPolicyMode(c.RestartPolicy),
+	}
+
+	resources := container.Resources{
+		Memory:   c.Memory,
+		NanoCPUs: int64(c.Cpu * math.Pow(10, 9)),
+	}
+
+	portBindings, err := ParsePortBindings(c.PortBindings)
+	if err != nil {
+		return RuntimeResult{Error: err}
+	}
+	exposedPorts := nat.PortSet{}
+	for port := range c.ExposedPorts {
+		exposedPorts[port] = struct{}{}
+	}
+	for port := range portBindings {
+		exposedPorts[port] = struct{}{}
+	}
+
+	err = ValidateMounts(c.Mounts)
+	if err != nil {
+		return RuntimeResult{Error: err}
+	}
+	var mounts []mount.Mount
+	var removeVolumes []string
+	// volumes created for this start, removed again if it fails
+	var created []string
+	for _, m := range c.Mounts {
+		if m.Type == string(mount.TypeVolume) && m.Source != "" {
+			// volumes that existed before the task belong to someone else
+			// and are never removed with it
+			_, err = docker.Client.VolumeInspect(ctx, m.Source)
+			if err == nil {
+				mounts = append(mounts, dockerMount(m))
+				continue
+			}
+			if !client.IsErrNotFound(err) {
+				log.Printf("Error inspecting volume %s: %v\n", m.Source, err)
+				docker.removeVolumes(ctx, created)
+				return RuntimeResult{Error: err}
+			}
+			_, err = docker.Client.VolumeCreate(ctx, volume.CreateOptions{Name: m.Source})
+			if err != nil {
+				log.Printf("Error creating volume %s: %v\n", m.Source, err)
+				docker.removeVolumes(ctx, created)
+				return RuntimeResult{Error: err}
+			}
+			created = append(created, m.Source)
+			if !m.Retain {
+				removeVolumes = append(removeVolumes, m.Source)
+			}
+		}
+		mounts = append(mounts, dockerMount(m))
+	}
+
+	containerConfig := container.Config{
+		Image:        c.Image,
+		Tty:          false,
+		Env:          c.Env,
+		ExposedPorts: exposedPorts,
+		WorkingDir:   c.WorkingDir,
+		User:         c.User,
+		Labels:       map[string]string{volumeRemoveLabel: strings.Join(removeVolumes, ",")},
+	}
+	if len(c.Entrypoint) > 0 {
+		containerConfig.Entrypoint = c.Entrypoint
+	}
+	if len(c.Cmd) > 0 {
+		containerConfig.Cmd = c.Cmd
+	}
+
+	hostConfig := container.HostConfig{
+		RestartPolicy:   restartPolicy,
+		Resources:       resources,
+		PortBindings:    portBindings,
+		PublishAllPorts: len(portBindings) == 0,
+		Mounts:          mounts,
+	}
+
+	resp, err := docker.Client.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, c.Name)
+	if err != nil {
+		log.Printf("Error creating container using image %s: %v\n", c.Image, err)
+		docker.removeVolumes(ctx, created)
+		return RuntimeResult{Error: err}
+	}
+
+	err = docker.Client.ContainerStart(ctx, resp.ID, container.StartOptions{})
+	if err != nil {
+		log.Printf("Error starting container %s: %v\n", resp.ID, err)
+		// the container holds on to its volumes until it is gone
+		rerr := docker.Client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+		if rerr != nil {
+			log.Printf("Error removing container %s: %v\n", resp.ID, rerr)
+		}
+		docker.removeVolumes(ctx, created)
+		return RuntimeResult{Error: err}
+	}
+
+	return RuntimeResult{ContainerId: resp.ID, Action: "start", Result: "success"}
+}
+
+func (docker *Docker) Stop(id string, remove bool) RuntimeResult {
+	log.Printf("Attempting to stop container %v", id)
+	ctx := context.Background()
+	err := docker.Client.ContainerStop(ctx, id, container.StopOptions{})
+	if err != nil {
+		log.Printf("Error stopping container %s: %v\n", id, err)
+		return RuntimeResult{Error: err}
+	}
+
+	if remove {
+		var volumes []string
+		resp, err := docker.Client.ContainerInspect(ctx, id)
+		if err == nil && resp.Config != nil && resp.Config.Labels[volumeRemoveLabel] != "" {
+			volumes = strings.Split(resp.Config.Labels[volumeRemoveLabel], ",")
+		}
+
+		err = docker.Client.ContainerRemove(ctx, id, container.RemoveOptions{
+			RemoveVolumes: true,
+			RemoveLinks:   false,
+			Force:         false,
+		})
+		if err != nil {
+			log.Printf("Error removing container %s: %v\n", id, err)
+			return RuntimeResult{Error: err}
+		}
+
+		docker.removeVolumes(ctx, volumes)
+	}
+
+	return RuntimeResult{Action: "stop", Result: "success", Error: nil}
+}
+
+// removeVolumes removes the named volumes, logging those that can't be.
+func (docker *Docker) removeVolumes(ctx context.Context, volumes []string) {
+	for _, v := range volumes {
+		err := docker.Client.VolumeRemove(ctx, v, false)
+		if err != nil {
+			log.Printf("Error removing volume %s: %v\n", v, err)
+		}
+	}
+}
+
+func (docker *Docker) Inspect(containerID string) InspectResponse {
+	ctx := context.Background()
+	resp, err := docker.Client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		log.Printf("Error inspecting container: %s\n", err)
+		return InspectResponse{Error: err}
+	}
+
+	r := InspectResponse{Container: &resp}
+	if resp.State != nil {
+		r.Status = resp.State.Status
+		r.ExitCode = resp.State.ExitCode
+		r.OOMKilled = resp.State.OOMKilled
+		r.StateError = resp.State.Error
+	}
+	if resp.NetworkSettings != nil {
+		r.Ports = resp.NetworkSettings.NetworkSettingsBase.Ports
+	}
+	return r
+}
+
+func (docker *Docker) Logs(ctx context.Context, containerID string, opts LogsOptions, stdout io.Writer, stderr io.Writer) error {
+	out, err := docker.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: opts.Stdout,
+		ShowStderr: opts.Stderr,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+	})
+	if err != nil {
+		log.Printf("Error getting logs for container %s: %v\n", containerID, err)
+		return err
+	}
+	defer out.Close()
+
+	_, err = stdcopy.StdCopy(stdout, stderr, out)
+	return err
+}
+
+func (docker *Docker) Stats(containerID string) StatsResponse {
+	ctx := context.Background()
+	resp, err := docker.Client.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		log.Printf("Error getting stats for container %s: %v\n", containerID, err)
+		return StatsResponse{Error: err}
+	}
+	defer resp.Body.Close()
+
+	var s types.StatsJSON
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return StatsResponse{Error: err}
+	}
+
+	return StatsResponse{Stats: &ContainerStats{
+		CpuUsage:    s.CPUStats.CPUUsage.TotalUsage,
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+	}}
+}
+
+func (docker *Docker) Exec(ctx context.Context, containerID string, opts ExecOptions) (*ExecSession, error) {
+	config := types.ExecConfig{
+		User:         opts.User,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          opts.Env,
+		WorkingDir:   opts.WorkingDir,
+		Cmd:          opts.Cmd,
+	}
+	if opts.Tty && opts.Height > 0 && opts.Width > 0 {
+		config.ConsoleSize = &[2]uint{opts.Height, opts.Width}
+	}
+
+	created, err := docker.Client.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		log.Printf("Error creating exec in container %s: %v\n", containerID, err)
+		return nil, err
+	}
+
+	resp, err := docker.Client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{
+		Tty:         opts.Tty,
+		ConsoleSize: config.ConsoleSize,
+	})
+	if err != nil {
+		log.Printf("Error attaching to exec %s: %v\n", created.ID, err)
+		return nil, err
+	}
+
+	return &ExecSession{ID: created.ID, Tty: opts.Tty, Stream: &hijackedStream{resp}}, nil
+}
+
+func (docker *Docker) ExecResize(execID string, height uint, width uint) error {
+	return docker.Client.ContainerExecResize(context.Background(), execID, container.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+func (docker *Docker) ExecInspect(execID string) ExecInspectResponse {
+	resp, err := docker.Client.ContainerExecInspect(context.Background(), execID)
+	if err != nil {
+		return ExecInspectResponse{Error: err}
+	}
+	return ExecInspectResponse{ContainerID: resp.ContainerID, Running: resp.Running, ExitCode: resp.ExitCode}
+}
+
+// hijackedStream adapts the connection of an attached exec to ExecStream,
+// reading through the response's buffered reader so no output is lost.
+type hijackedStream struct {
+	types.HijackedResponse
+}
+
+func (h *hijackedStream) Read(p []byte) (int, error) {
+	return h.Reader.Read(p)
+}
+
+func (h *hijackedStream) Write(p []byte) (int, error) {
+	return h.Conn.Write(p)
+}
+
+func (h *hijackedStream) Close() error {
+	return h.Conn.Close()
+}
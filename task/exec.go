@@ -0,0 +1,419 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Exec runs tasks as supervised processes on the worker host. Stdout and
+// stderr are captured to files in LogDir and, when the host uses cgroup v2,
+// every process is placed in its own cgroup carrying the task's limits.
+type Exec struct {
+	mu        sync.Mutex
+	LogDir    string
+	Processes map[string]*Process
+}
+
+type Process struct {
+	Cmd        *exec.Cmd
+	Status     string
+	ExitCode   int
+	StdoutPath string
+	StderrPath string
+	Cgroup     string
+	done       chan struct{}
+	// group guards signalling the process group against the process being
+	// reaped, after which the group's id can be reused by another process
+	group      sync.Mutex
+	reaped     bool
+}
+
+func NewExec() (*Exec, error) {
+	dir := filepath.Join(os.TempDir(), "cube", "exec")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Exec{
+		LogDir:    dir,
+		Processes: make(map[string]*Process),
+	}, nil
+}
+
+func (e *Exec) Run(c *ContainerConfig) RuntimeResult {
+	argv := append(append([]string{}, c.Entrypoint...), c.Cmd...)
+	if len(argv) == 0 {
+		return RuntimeResult{Error: errors.New("exec runtime requires a command")}
+	}
+	if len(c.Mounts) > 0 {
+		return RuntimeResult{Error: errors.New("exec runtime does not support mounts")}
+	}
+	if c.User != "" {
+		log.Printf("exec runtime does not support running as user %s, using the worker's user\n", c.User)
+	}
+
+	id := uuid.New().String()
+	p := &Process{
+		StdoutPath: filepath.Join(e.LogDir, id+".stdout"),
+		StderrPath: filepath.Join(e.LogDir, id+".stderr"),
+		done:       make(chan struct{}),
+	}
+
+	stdout, err := os.Create(p.StdoutPath)
+	if err != nil {
+		return RuntimeResult{Error: err}
+	}
+	stderr, err := os.Create(p.StderrPath)
+	if err != nil {
+		stdout.Close()
+		return RuntimeResult{Error: err}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = processEnv(c.Env)
+	cmd.Dir = c.WorkingDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// the process leads its own group so that Stop reaches its children too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	p.Cmd = cmd
+
+	cgroup, err := createCgroup(id, c)
+	if err != nil {
+		log.Printf("Unable to apply cgroup limits to process %s: %v\n", argv[0], err)
+	}
+	if cgroup != nil {
+		// the process starts inside the cgroup, so it and anything it
+		// forks are limited from the start
+		defer cgroup.Close()
+		p.Cgroup = cgroup.Name()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroup.Fd())
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		log.Printf("Error starting process %s: %v\n", argv[0], err)
+		stdout.Close()
+		stderr.Close()
+		if p.Cgroup != "" {
+			os.Remove(p.Cgroup)
+		}
+		return RuntimeResult{Error: err}
+	}
+	p.Status = "running"
+
+	e.mu.Lock()
+	e.Processes[id] = p
+	e.mu.Unlock()
+
+	go func() {
+		// the exited process isn't reaped until its children are killed,
+		// so its id still names the task's group when they are
+		waitExited(cmd.Process.Pid)
+		p.group.Lock()
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+		p.reaped = true
+		p.group.Unlock()
+
+		stdout.Close()
+		stderr.Close()
+
+		e.mu.Lock()
+		p.Status = "exited"
+		p.ExitCode = cmd.ProcessState.ExitCode()
+		e.mu.Unlock()
+		close(p.done)
+	}()
+
+	return RuntimeResult{ContainerId: id, Action: "start", Result: "success"}
+}
+
+func (e *Exec) Stop(id string, remove bool) RuntimeResult {
+	log.Printf("Attempting to stop process %v", id)
+	p, err := e.process(id)
+	if err != nil {
+		return RuntimeResult{Error: err}
+	}
+
+	// signal the process group, the process's children go with it
+	select {
+	case <-p.done:
+	default:
+		p.signal(syscall.SIGTERM)
+		select {
+		case <-p.done:
+		case <-time.After(10 * time.Second):
+			p.signal(syscall.SIGKILL)
+			<-p.done
+		}
+	}
+
+	if remove {
+		e.mu.Lock()
+		delete(e.Processes, id)
+		e.mu.Unlock()
+		os.Remove(p.StdoutPath)
+		os.Remove(p.StderrPath)
+		if p.Cgroup != "" {
+			os.Remove(p.Cgroup)
+		}
+	}
+
+	return RuntimeResult{Action: "stop", Result: "success"}
+}
+
+// signal sends sig to the process's group, unless the process was reaped and
+// the group is gone.
+func (p *Process) signal(sig syscall.Signal) {
+	p.group.Lock()
+	defer p.group.Unlock()
+	if !p.reaped {
+		syscall.Kill(-p.Cmd.Process.Pid, sig)
+	}
+}
+
+// waitExited waits for the process to exit without reaping it.
+func waitExited(pid int) {
+	var info unix.Siginfo
+	for {
+		err := unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+		if err != unix.EINTR {
+			return
+		}
+	}
+}
+
+func (e *Exec) Inspect(id string) InspectResponse {
+	p, err := e.process(id)
+	if err != nil {
+		return InspectResponse{Error: err}
+	}
+
+	e.mu.Lock()
+	r := InspectResponse{Status: p.Status, ExitCode: p.ExitCode}
+	e.mu.Unlock()
+
+	if r.Status == "exited" && p.Cgroup != "" {
+		r.OOMKilled = cgroupOOMKilled(p.Cgroup)
+	}
+	return r
+}
+
+func (e *Exec) Logs(ctx context.Context, id string, opts LogsOptions, stdout io.Writer, stderr io.Writer) error {
+	p, err := e.process(id)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	if opts.Stdout {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[0] = followFile(ctx, p.StdoutPath, stdout, opts, p.done)
+		}()
+	}
+	if opts.Stderr {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[1] = followFile(ctx, p.StderrPath, stderr, opts, p.done)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (e *Exec) Stats(id string) StatsResponse {
+	p, err := e.process(id)
+	if err != nil {
+		return StatsResponse{Error: err}
+	}
+
+	s := ContainerStats{}
+	if p.Cgroup == "" {
+		return StatsResponse{Stats: &s}
+	}
+
+	cpu, err := os.ReadFile(filepath.Join(p.Cgroup, "cpu.stat"))
+	if err == nil {
+		for _, line := range strings.Split(string(cpu), "\n") {
+			f := strings.Fields(line)
+			if len(f) == 2 && f[0] == "usage_usec" {
+				usec, _ := strconv.ParseUint(f[1], 10, 64)
+				s.CpuUsage = usec * 1000
+			}
+		}
+	}
+	s.MemoryUsage = readCgroupUint(filepath.Join(p.Cgroup, "memory.current"))
+	s.MemoryLimit = readCgroupUint(filepath.Join(p.Cgroup, "memory.max"))
+
+	return StatsResponse{Stats: &s}
+}
+
+func (e *Exec) process(id string) (*Process, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.Processes[id]
+	if !ok {
+		return nil, fmt.Errorf("process %s does not exist", id)
+	}
+	return p, nil
+}
+
+// processEnv returns the task's environment, falling back to the worker's
+// PATH so that bare command names can still be resolved.
+func processEnv(env []string) []string {
+	for _, v := range env {
+		if strings.HasPrefix(v, "PATH=") {
+			return env
+		}
+	}
+	return append([]string{"PATH=" + os.Getenv("PATH")}, env...)
+}
+
+// createCgroup creates a cgroup v2 group with the cpu and memory limits from
+// c and returns it opened, for the process to be started in. It returns nil
+// when cgroup v2 is not available.
+func createCgroup(id string, c *ContainerConfig) (*os.File, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, nil
+	}
+
+	parent := filepath.Join(cgroupRoot, "cube")
+	err := os.MkdirAll(parent, 0755)
+	if err != nil {
+		return nil, err
+	}
+	// limits are only applied once the controllers are enabled for the
+	// children of every group above the task's
+	for _, dir := range []string{cgroupRoot, parent} {
+		err = os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+		if err != nil {
+			return nil, fmt.Errorf("enabling cpu and memory controllers in %s: %v", dir, err)
+		}
+	}
+
+	dir := filepath.Join(parent, id)
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Cpu > 0 {
+		quota := int64(c.Cpu * 100000)
+		err = os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%d 100000", quota)), 0644)
+	}
+	if err == nil && c.Memory > 0 {
+		err = os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(c.Memory, 10)), 0644)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(dir)
+	}
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return f, nil
+}
+
+// cgroupOOMKilled reports whether the kernel OOM killer has killed a
+// process in the cgroup.
+func cgroupOOMKilled(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == "oom_kill" && f[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+func readCgroupUint(path string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v
+}
+
+// followFile copies the (optionally tailed) contents of path to w. With
+// opts.Follow set it keeps polling for new output until the process exits or
+// ctx is cancelled.
+func followFile(ctx context.Context, path string, w io.Writer, opts LogsOptions, done chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(tailLines(data, opts.Tail))
+	if err != nil || !opts.Follow {
+		return err
+	}
+
+	for {
+		_, err = io.Copy(w, f)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			_, err = io.Copy(w, f)
+			return err
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// tailLines returns the last n lines of data, where n is parsed from tail.
+// An empty tail or "all" returns data unchanged.
+func tailLines(data []byte, tail string) []byte {
+	n, err := strconv.Atoi(tail)
+	if err != nil || n < 0 {
+		return data
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil)
+}
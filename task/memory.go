@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// InMemoryRuntime is a fake runtime which keeps its workloads in a map.
+// It lets the worker loop run without a Docker daemon.
+type InMemoryRuntime struct {
+	mu sync.Mutex
+	Db map[string]*InMemoryWorkload
+}
+
+type InMemoryWorkload struct {
+	Config   ContainerConfig
+	Status   string
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+func NewInMemoryRuntime() *InMemoryRuntime {
+	return &InMemoryRuntime{
+		Db: make(map[string]*InMemoryWorkload),
+	}
+}
+
+func (i *InMemoryRuntime) Run(c *ContainerConfig) RuntimeResult {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	id := uuid.New().String()
+	i.Db[id] = &InMemoryWorkload{Config: *c, Status: "running"}
+	return RuntimeResult{ContainerId: id, Action: "start", Result: "success"}
+}
+
+func (i *InMemoryRuntime) Stop(id string, remove bool) RuntimeResult {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	w, ok := i.Db[id]
+	if !ok {
+		return RuntimeResult{Error: fmt.Errorf("workload %s does not exist", id)}
+	}
+	w.Status = "exited"
+	if remove {
+		delete(i.Db, id)
+	}
+	return RuntimeResult{Action: "stop", Result: "success"}
+}
+
+// Exit marks a running workload as exited with the given code, as if its
+// process had terminated on its own.
+func (i *InMemoryRuntime) Exit(id string, code int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	w, ok := i.Db[id]
+	if !ok {
+		return fmt.Errorf("workload %s does not exist", id)
+	}
+	w.Status = "exited"
+	w.ExitCode = code
+	return nil
+}
+
+func (i *InMemoryRuntime) Inspect(id string) InspectResponse {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	w, ok := i.Db[id]
+	if !ok {
+		return InspectResponse{Error: fmt.Errorf("workload %s does not exist", id)}
+	}
+	return InspectResponse{Status: w.Status, ExitCode: w.ExitCode}
+}
+
+func (i *InMemoryRuntime) Logs(ctx context.Context, id string, opts LogsOptions, stdout io.Writer, stderr io.Writer) error {
+	i.mu.Lock()
+	w, ok := i.Db[id]
+	if !ok {
+		i.mu.Unlock()
+		return fmt.Errorf("workload %s does not exist", id)
+	}
+	outBuf := append([]byte(nil), w.Stdout...)
+	errBuf := append([]byte(nil), w.Stderr...)
+	i.mu.Unlock()
+
+	if opts.Stdout {
+		if _, err := stdout.Write(outBuf); err != nil {
+			return err
+		}
+	}
+	if opts.Stderr {
+		if _, err := stderr.Write(errBuf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *InMemoryRuntime) Stats(id string) StatsResponse {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	w, ok := i.Db[id]
+	if !ok {
+		return StatsResponse{Error: fmt.Errorf("workload %s does not exist", id)}
+	}
+	return StatsResponse{Stats: &ContainerStats{MemoryLimit: uint64(w.Config.Memory)}}
+}
@@ -0,0 +1,81 @@
+package task
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// volumeRemoveLabel is set on containers to list the named volumes that
+// should be removed together with the container.
+const volumeRemoveLabel = "cube.volumes.remove"
+
+// Mount is storage attached to a task.
+type Mount struct {
+	// Type of the mount: "volume", "bind" or "tmpfs"
+	Type string
+	// Source is the volume name or host path. A volume without a name is
+	// anonymous and is always removed with the task. Unused for tmpfs.
+	Source   string
+	Target   string
+	ReadOnly bool
+	// Retain keeps a named volume created for the task after the task is
+	// stopped and removed. Volumes that already existed are always kept
+	Retain bool
+	// Size of a tmpfs mount in bytes, unlimited if zero
+	Size int64
+}
+
+func (m Mount) Validate() error {
+	if !filepath.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+
+	switch mount.Type(m.Type) {
+	case mount.TypeVolume:
+		if strings.Contains(m.Source, "/") {
+			return fmt.Errorf("volume name %q must not contain /", m.Source)
+		}
+	case mount.TypeBind:
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("bind mount source %q must be an absolute path", m.Source)
+		}
+	case mount.TypeTmpfs:
+		if m.Source != "" {
+			return fmt.Errorf("tmpfs mount on %s must not have a source", m.Target)
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+	return nil
+}
+
+func ValidateMounts(mounts []Mount) error {
+	targets := make(map[string]bool)
+	for _, m := range mounts {
+		err := m.Validate()
+		if err != nil {
+			return err
+		}
+		if targets[m.Target] {
+			return fmt.Errorf("duplicate mount target %s", m.Target)
+		}
+		targets[m.Target] = true
+	}
+	return nil
+}
+
+func dockerMount(m Mount) mount.Mount {
+	dm := mount.Mount{
+		Type:     mount.Type(m.Type),
+		Source:   m.Source,
+		Target:   m.Target,
+		ReadOnly: m.ReadOnly,
+	}
+	if dm.Type == mount.TypeTmpfs && m.Size > 0 {
+		dm.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: m.Size}
+	}
+	return dm
+}
@@ -0,0 +1,96 @@
+package task
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// HostPort is a port on the worker host that a task binds to.
+type HostPort struct {
+	Proto string
+	IP    string
+	Port  string
+}
+
+func (h HostPort) String() string {
+	return fmt.Sprintf("%s/%s", net.JoinHostPort(h.IP, h.Port), h.Proto)
+}
+
+// Conflicts reports whether h and o can't both be bound on the same host.
+// An empty or unspecified IP binds every interface and so conflicts with any
+// other IP on the same port.
+func (h HostPort) Conflicts(o HostPort) bool {
+	if h.Proto != o.Proto || h.Port != o.Port {
+		return false
+	}
+	return h.IP == o.IP || isAnyIP(h.IP) || isAnyIP(o.IP)
+}
+
+func isAnyIP(ip string) bool {
+	if ip == "" {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsUnspecified()
+}
+
+// ParsePortBindings converts a task's PortBindings into a nat.PortMap. Keys
+// are container ports ("8080/tcp", "53/udp") and values are host ports in
+// [hostIP:]hostPort form, e.g. "8080" or "127.0.0.1:8080".
+func ParsePortBindings(bindings map[string]string) (nat.PortMap, error) {
+	portMap := nat.PortMap{}
+	for containerPort, host := range bindings {
+		spec := containerPort
+		if host != "" {
+			spec = host + ":" + containerPort
+		}
+		mappings, err := nat.ParsePortSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port binding %s -> %s: %v", containerPort, host, err)
+		}
+		for _, m := range mappings {
+			portMap[m.Port] = append(portMap[m.Port], m.Binding)
+		}
+	}
+	return portMap, nil
+}
+
+// HostPorts returns the fixed host ports requested by bindings, sorted so
+// that the result is stable. Bindings without a host port get a random one
+// from Docker and are not included.
+func HostPorts(bindings map[string]string) ([]HostPort, error) {
+	portMap, err := ParsePortBindings(bindings)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []HostPort
+	for port, bs := range portMap {
+		for _, b := range bs {
+			if b.HostPort == "" {
+				continue
+			}
+			ports = append(ports, HostPort{Proto: port.Proto(), IP: b.HostIP, Port: b.HostPort})
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].String() < ports[j].String()
+	})
+	return ports, nil
+}
+
+// PortConflict returns the first port in requested that conflicts with one in
+// allocated, or nil if they can all be bound.
+func PortConflict(requested []HostPort, allocated []HostPort) *HostPort {
+	for _, r := range requested {
+		for _, a := range allocated {
+			if r.Conflicts(a) {
+				return &r
+			}
+		}
+	}
+	return nil
+}
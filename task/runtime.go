@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-connections/nat"
+)
+
+// Runtime is the backend a worker uses to run the workload of a task.
+// Implementations are long-lived and are keyed by the id they return from Run.
+type Runtime interface {
+	Run(c *ContainerConfig) RuntimeResult
+	Stop(id string, remove bool) RuntimeResult
+	Inspect(id string) InspectResponse
+	Logs(ctx context.Context, id string, opts LogsOptions, stdout io.Writer, stderr io.Writer) error
+	Stats(id string) StatsResponse
+}
+
+// Execer is implemented by runtimes that can run an extra command inside a
+// running task.
+type Execer interface {
+	Exec(ctx context.Context, id string, opts ExecOptions) (*ExecSession, error)
+	ExecResize(execID string, height uint, width uint) error
+	ExecInspect(execID string) ExecInspectResponse
+}
+
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "docker":
+		return NewDocker()
+	case "exec":
+		return NewExec()
+	case "memory":
+		return NewInMemoryRuntime(), nil
+	}
+	return nil, fmt.Errorf("unknown runtime %q", name)
+}
+
+type RuntimeResult struct {
+	Error       error
+	Action      string
+	ContainerId string
+	Result      string
+}
+
+type InspectResponse struct {
+	Error error
+	// Status of the workload as reported by the runtime ("running", "exited", ...)
+	Status    string
+	ExitCode  int
+	OOMKilled bool
+	// StateError is the error reported by the runtime for the workload, if any
+	StateError string
+	Ports      nat.PortMap
+	// Container is only set by the docker runtime
+	Container *types.ContainerJSON
+}
+
+type LogsOptions struct {
+	Follow bool
+	Tail   string
+	Since  string
+	Stdout bool
+	Stderr bool
+}
+
+type ContainerStats struct {
+	// CpuUsage is the total CPU time consumed in nanoseconds
+	CpuUsage    uint64
+	MemoryUsage uint64
+	MemoryLimit uint64
+}
+
+type StatsResponse struct {
+	Error error
+	Stats *ContainerStats
+}
+
+type ExecOptions struct {
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string
+	// Tty allocates a terminal for the command, Height and Width set its
+	// initial size
+	Tty    bool
+	Height uint
+	Width  uint
+	// Stdin attaches the caller's input to the command
+	Stdin bool
+}
+
+// ExecStream carries the input of an exec session one way and its output
+// the other. Without a Tty the output multiplexes stdout and stderr in the
+// Docker stdcopy format.
+type ExecStream interface {
+	io.ReadWriteCloser
+	CloseWrite() error
+}
+
+type ExecSession struct {
+	ID     string
+	Tty    bool
+	Stream ExecStream
+}
+
+type ExecInspectResponse struct {
+	Error    error `json:"-"`
+	// ContainerID of the workload the exec session runs in
+	ContainerID string `json:"-"`
+	Running  bool
+	ExitCode int
+}
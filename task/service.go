@@ -0,0 +1,142 @@
+package task
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rollout states of a Service.
+const (
+	// RolloutComplete means every task runs the current revision
+	RolloutComplete = "Complete"
+	// RolloutProgressing means tasks of older revisions are being replaced
+	RolloutProgressing = "Progressing"
+	// RolloutRollingBack means a previous template is being restored
+	RolloutRollingBack = "RollingBack"
+	// RolloutPaused means the rollout stopped after a failure and waits to
+	// be resumed or undone
+	RolloutPaused = "Paused"
+)
+
+// Failure actions of an UpdateConfig.
+const (
+	FailurePause    = "pause"
+	FailureRollback = "rollback"
+)
+
+// Service keeps Replicas copies of Task running. The manager's reconciler
+// replaces copies that fail or are stopped and adds or removes copies when
+// Replicas changes. Changing Task creates a new revision that is rolled out
+// according to UpdateConfig.
+type Service struct {
+	ID       uuid.UUID
+	Name     string
+	Replicas int
+	Task     Task
+	// Tasks holds the IDs of the service's tasks that haven't finished,
+	// oldest first
+	Tasks        []uuid.UUID
+	UpdateConfig UpdateConfig
+	// Revision is the number of the current template in Revisions
+	Revision int
+	// Revisions holds the last RevisionHistoryLimit templates, oldest first
+	Revisions            []ServiceRevision
+	RevisionHistoryLimit int
+	Rollout              RolloutStatus
+	CreatedTime          time.Time
+}
+
+// UpdateConfig controls how a new revision of a service replaces the old one.
+type UpdateConfig struct {
+	// MaxSurge is the number of tasks that can run above Replicas
+	MaxSurge int
+	// MaxUnavailable is the number of replicas that can be unavailable
+	MaxUnavailable int
+	// HealthTimeout is the number of seconds a new task has to pass its
+	// health check before the rollout fails
+	HealthTimeout int
+	// Monitor is the number of seconds a new task has to keep running
+	// before it counts as available
+	Monitor int
+	// FailureAction is FailurePause or FailureRollback
+	FailureAction string
+}
+
+type ServiceRevision struct {
+	Revision    int
+	Task        Task
+	CreatedTime time.Time
+}
+
+type RolloutStatus struct {
+	Status      string
+	Message     string
+	UpdatedTime time.Time
+}
+
+func (s *Service) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("service name is required")
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	if s.UpdateConfig.MaxSurge < 0 || s.UpdateConfig.MaxUnavailable < 0 || s.UpdateConfig.HealthTimeout < 0 || s.UpdateConfig.Monitor < 0 || s.RevisionHistoryLimit < 0 {
+		return fmt.Errorf("update settings must not be negative")
+	}
+	switch s.UpdateConfig.FailureAction {
+	case "", FailurePause, FailureRollback:
+	default:
+		return fmt.Errorf("unknown failure action %q", s.UpdateConfig.FailureAction)
+	}
+	if s.Task.IsJob() {
+		return fmt.Errorf("service tasks can't be jobs")
+	}
+	if _, err := ParsePortBindings(s.Task.PortBindings); err != nil {
+		return err
+	}
+	if err := ValidatePlacement(&s.Task); err != nil {
+		return err
+	}
+	return ValidateMounts(s.Task.Mounts)
+}
+
+// TemplateOf returns the template of revision n.
+func (s *Service) TemplateOf(n int) (*Task, error) {
+	for _, r := range s.Revisions {
+		if r.Revision == n {
+			return &r.Task, nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d of service %s not found", n, s.Name)
+}
+
+// PreviousRevision returns the number of the revision before the current
+// one, or 0 if there is none.
+func (s *Service) PreviousRevision() int {
+	previous := 0
+	for _, r := range s.Revisions {
+		if r.Revision < s.Revision && r.Revision > previous {
+			previous = r.Revision
+		}
+	}
+	return previous
+}
+
+// IsCurrent reports whether tasks of revision n run the current template,
+// which is the case for the revision a rollback restored.
+func (s *Service) IsCurrent(n int) bool {
+	if n == s.Revision {
+		return true
+	}
+	t, err := s.TemplateOf(n)
+	return err == nil && reflect.DeepEqual(*t, s.Task)
+}
+
+// RollingOut reports whether a rollout of the current revision is under way.
+func (s *Service) RollingOut() bool {
+	return s.Rollout.Status == RolloutProgressing || s.Rollout.Status == RolloutRollingBack
+}
@@ -1,21 +1,17 @@
 package task
 
 import (
-	"io"
-	"log"
-	"math"
-	"os"
 	"time"
 
-	"context"
-
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
-	"github.com/moby/moby/pkg/stdcopy"
+)
+
+// Task types. Services are expected to run until they are stopped, so any
+// exit is a failure. Jobs run to completion and exit code 0 completes them.
+const (
+	TypeService = "service"
+	TypeJob     = "job"
 )
 
 type Task struct {
@@ -24,6 +20,10 @@ type Task struct {
 	Name          string
 	State         State
 	Image         string
+	// Priority orders pending tasks, the higher the earlier. With preemption
+	// a task can evict tasks with a lower priority to make room.
+	Priority      int
+	// Resources the task requests: Cpu in cores, Memory and Disk in bytes
 	Cpu           float64
 	Memory        int64
 	Disk          int64
@@ -35,6 +35,48 @@ type Task struct {
 	HealthCheck   string
 	RestartCount  int
 	HostPorts			nat.PortMap
+	// Runtime used to run the task ("docker" or "exec"), the worker default if empty
+	Runtime       string
+	// Entrypoint overrides the image's entrypoint when set
+	Entrypoint    []string
+	// Cmd is the command to run, with Args appended to it
+	Cmd           []string
+	Args          []string
+	// Env variables in KEY=value form
+	Env           []string
+	WorkingDir    string
+	// User the command is run as, in user[:group] form
+	User          string
+	// Mounts of volumes, host paths and tmpfs into the task
+	Mounts        []Mount
+	// Type of the task. An empty type is a service, except for exec tasks
+	// which then complete when their process exits with code 0
+	Type          string
+	// ExitCode, OOMKilled and Error describe how the task's workload exited
+	ExitCode      int
+	OOMKilled     bool
+	Error         string
+	// ServiceID of the service the task is a replica of, if any
+	ServiceID     uuid.UUID
+	// ServiceRevision of the service's template the task was created from
+	ServiceRevision int
+	// Labels other tasks select the task by in their anti-affinity and
+	// topology spread rules
+	Labels        map[string]string
+	// NodeSelector lists labels a node must have for the task to run on it
+	NodeSelector  map[string]string
+	// Affinity rules on the labels of the nodes the task can run on
+	Affinity      *Affinity
+	// AntiAffinity rules keeping the task away from other tasks
+	AntiAffinity  []AntiAffinity
+	// TopologySpread constraints spreading the task and its peers over nodes
+	TopologySpread []TopologySpread
+	// Tolerations of the taints of the nodes the task can run on
+	Tolerations   []Toleration
+}
+
+func (t *Task) IsJob() bool {
+	return t.Type == TypeJob
 }
 
 type TaskEvent struct {
@@ -56,18 +98,28 @@ type ContainerConfig struct {
 	AttachStderr bool
 	// ExposedPorts list of ports exposed
 	ExposedPorts nat.PortSet
+	// PortBindings of container ports to [hostIP:]hostPort on the worker
+	PortBindings map[string]string
+	// Entrypoint of the container, overrides the image's (optional)
+	Entrypoint []string
 	// Cmd to be run inside container (optional)
 	Cmd []string
 	// Image used to run the container
 	Image string
 	// Cpu
 	Cpu float64
-	// Memory in MiB
+	// Memory in bytes
 	Memory int64
-	// Disk in GiB
+	// Disk in bytes
 	Disk int64
 	// Env variables
 	Env []string
+	// WorkingDir the command is run in
+	WorkingDir string
+	// User the command is run as
+	User string
+	// Mounts attached to the container
+	Mounts []Mount
 
 	RestartPolicy string
 }
@@ -76,132 +128,17 @@ func NewConfig(t*Task) *ContainerConfig {
 	return &ContainerConfig{
 		Name: t.Name,
 		ExposedPorts: t.ExposedPorts,
+		PortBindings: t.PortBindings,
 		Image: t.Image,
 		Cpu: t.Cpu,
 		Memory: t.Memory,
 		Disk: t.Disk,
 		RestartPolicy: t.RestartPolicy,
+		Entrypoint: t.Entrypoint,
+		Cmd: append(append([]string{}, t.Cmd...), t.Args...),
+		Env: t.Env,
+		WorkingDir: t.WorkingDir,
+		User: t.User,
+		Mounts: t.Mounts,
 	}
 }
-
-
-
-type Docker struct {
-	Client *client.Client
-	Config   ContainerConfig
-}
-
-
-type DockerInspectResponse struct{
-	Error     error
-	Container *types.ContainerJSON
-}
-
-func NewDocker(c *ContainerConfig) *Docker {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	return &Docker{
-		Client: dc,
-		Config: *c,
-	}
-}
-
-type DockerResult struct {
-	Error       error
-	Action      string
-	ContainerId string
-	Result      string
-}
-
-
-func (docker *Docker) Run() DockerResult {
-	ctx := context.Background()
-	reader, err := docker.Client.ImagePull(ctx, docker.Config.Image, image.PullOptions{})
-	if err != nil {
-			log.Printf("Error pulling image %s: %v\n", docker.Config.Image, err)
-			return DockerResult{Error: err}
-	}
-	io.Copy(os.Stdout, reader)
-
-	restartPolicy := container.RestartPolicy{
-			Name: container.RestartPolicyMode(docker.Config.RestartPolicy),
-	}
-
-	resources := container.Resources{
-			Memory: docker.Config.Memory,
-			NanoCPUs: int64(docker.Config.Cpu * math.Pow(10, 9)),
-	}
-
-	containerConfig := container.Config{
-			Image: docker.Config.Image,
-			Tty: false,
-			Env: docker.Config.Env,
-			ExposedPorts: docker.Config.ExposedPorts,
-	}
-
-	hostConfig := container.HostConfig{
-			RestartPolicy: restartPolicy,
-			Resources:     resources,
-			PublishAllPorts: true,
-	}
-
-	resp, err := docker.Client.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, docker.Config.Name)
-	if err != nil {
-			log.Printf("Error creating container using image %s: %v\n", docker.Config.Image, err)
-			return DockerResult{Error: err}
-	}
-
-	err = docker.Client.ContainerStart(ctx, resp.ID, container.StartOptions{})
-	if err != nil {
-			log.Printf("Error starting container %s: %v\n", resp.ID, err)
-			return DockerResult{Error: err}
-	}
-
-	out, err := docker.Client.ContainerLogs(ctx,resp.ID,container.LogsOptions{ShowStdout: true, ShowStderr: true})
-
-	if err != nil {
-			log.Printf("Error getting logs for container %s: %v\n", resp.ID, err)
-			return DockerResult{Error: err}
-	}
-
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-	return DockerResult{ContainerId: resp.ID, Action: "start",Result: "success"}
-}
-
-
-
-func (docker *Docker) Stop(id string,remove bool) DockerResult {
-	log.Printf("Attempting to stop container %v", id)
-	ctx := context.Background()
-	err := docker.Client.ContainerStop(ctx, id, container.StopOptions{})
-	if err != nil {
-			log.Printf("Error stopping container %s: %v\n", id, err)
-			return DockerResult{Error: err}
-	}
-
-	if remove {
-		err = docker.Client.ContainerRemove(ctx, id, container.RemoveOptions{
-			RemoveVolumes: true,
-			RemoveLinks:   false,
-			Force:         false,
-		})
-		if err != nil {
-				log.Printf("Error removing container %s: %v\n", id, err)
-				return DockerResult{Error: err}
-		}
-	}
-
-	return DockerResult{Action: "stop", Result: "success", Error: nil}
-}
-
-func (d *Docker) Inspect(containerID string) DockerInspectResponse {
-	dc, _ := client.NewClientWithOpts(client.FromEnv)
-	ctx := context.Background()
-	resp, err := dc.ContainerInspect(ctx, containerID)
-	if err != nil {
-			log.Printf("Error inspecting container: %s\n", err)
-			return DockerInspectResponse{Error: err}
-	}
-
-	return DockerInspectResponse{Container: &resp}
-}
-
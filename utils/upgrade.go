@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// UpgradeProtocol is the protocol requested in the Upgrade header when an
+// HTTP connection is switched to a raw exec stream.
+const UpgradeProtocol = "cube-exec"
+
+const (
+	ExecIDHeader  = "Cube-Exec-Id"
+	ExecTtyHeader = "Cube-Exec-Tty"
+)
+
+// HalfCloser is a bidirectional stream whose write side can be closed on its
+// own, signalling EOF to the peer while still reading its output.
+type HalfCloser interface {
+	io.ReadWriter
+	CloseWrite() error
+}
+
+// UpgradedConn is a connection that has switched protocols. Reads go through
+// Reader, which may hold bytes buffered while the HTTP headers were parsed.
+type UpgradedConn struct {
+	net.Conn
+	Reader *bufio.Reader
+}
+
+func (c *UpgradedConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func (c *UpgradedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// DialUpgrade sends req to addr over a new TCP connection asking to switch to
+// UpgradeProtocol. If the server doesn't switch, the response is returned
+// together with an error describing it.
+func DialUpgrade(addr string, req *http.Request) (*UpgradedConn, *http.Response, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", UpgradeProtocol)
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		return nil, resp, fmt.Errorf("%s did not switch protocols (%d): %s", addr, resp.StatusCode, body)
+	}
+
+	return &UpgradedConn{Conn: conn, Reader: br}, resp, nil
+}
+
+// WriteSwitchingProtocols writes the 101 response on a hijacked connection,
+// including any extra headers.
+func WriteSwitchingProtocols(w io.Writer, headers http.Header) error {
+	resp := http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     headers.Clone(),
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", UpgradeProtocol)
+	return resp.Write(w)
+}
+
+// Splice copies input from client to backend and output from backend to
+// client. When the client closes its side the backend's write side is
+// closed too; Splice returns once the backend's output is done.
+func Splice(client HalfCloser, backend HalfCloser) {
+	go func() {
+		io.Copy(backend, client)
+		backend.CloseWrite()
+	}()
+	io.Copy(client, backend)
+}
@@ -28,6 +28,10 @@ func (a *Api) initRouter() {
 		router.Route("/{taskId}",func(router chi.Router) {
 			router.Get("/", a.InspectTaskHandler)
 			router.Delete("/", a.StopTaskHandler)
+			router.Get("/logs", a.GetTaskLogsHandler)
+			router.Post("/exec", a.ExecTaskHandler)
+			router.Get("/exec/{execId}", a.InspectExecHandler)
+			router.Post("/exec/{execId}/resize", a.ResizeExecHandler)
 		})
 	})
 	a.Router.Route("/stats",func(router chi.Router) {
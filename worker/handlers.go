@@ -1,11 +1,17 @@
 package worker
 
 import (
+	"context"
 	"cube/task"
+	"cube/utils"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
@@ -75,10 +81,11 @@ func (a *Api) GetStats(res http.ResponseWriter,req *http.Request) {
 
 
 func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
-	taskID := chi.URLParam(r, "taskID")
+	taskID := chi.URLParam(r, "taskId")
 	if taskID == "" {
 		log.Printf("No taskID passed in request.\n")
 		w.WriteHeader(400)
+		return
 	}
 
 	tID, _ := uuid.Parse(taskID)
@@ -89,10 +96,226 @@ func (a *Api) InspectTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := a.Worker.InspectTask(t.(task.Task))
+	resp := a.Worker.InspectTask(*t.(*task.Task))
+	if resp.Error != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 500, Message: resp.Error.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if resp.Container != nil {
+		json.NewEncoder(w).Encode(resp.Container)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+
+}
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.taskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseLogsOptions(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 400, Message: err.Error()})
+		return
+	}
+
+	// once the logs start streaming the status can't change, so anything
+	// that stops them from being read is reported first
+	if _, err := a.Worker.runtimeFor(*t); err != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 500, Message: err.Error()})
+		return
+	}
+	err = fmt.Errorf("task has not started")
+	if t.ContainerID != "" {
+		err = a.Worker.InspectTask(*t).Error
+	}
+	if err != nil {
+		msg := fmt.Sprintf("No container for task %v: %v", t.ID, err)
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 404, Message: msg})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	out := &flushWriter{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		out.f = f
+	}
+
+	err = a.Worker.TaskLogs(r.Context(), *t, opts, out, out)
+	if err != nil {
+		log.Printf("Error streaming logs for task %v: %v\n", t.ID, err)
+	}
+}
+
+// parseLogsOptions reads the follow, tail, since, stdout and stderr query
+// parameters. Both streams are returned unless one of them is selected.
+func parseLogsOptions(q url.Values) (task.LogsOptions, error) {
+	opts := task.LogsOptions{
+		Tail:  q.Get("tail"),
+		Since: q.Get("since"),
+	}
+
+	var err error
+	for name, v := range map[string]*bool{"follow": &opts.Follow, "stdout": &opts.Stdout, "stderr": &opts.Stderr} {
+		if q.Get(name) == "" {
+			continue
+		}
+		*v, err = strconv.ParseBool(q.Get(name))
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	if !opts.Stdout && !opts.Stderr {
+		opts.Stdout = true
+		opts.Stderr = true
+	}
+	return opts, nil
+}
+
+// flushWriter flushes every write so that followed logs reach the client as
+// they are produced. Writes may come from the stdout and stderr copiers
+// concurrently.
+type flushWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// ExecTaskHandler starts a command inside a running task. The request must
+// ask to upgrade the connection; once switched, the connection carries the
+// command's input and output.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.taskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Upgrade") != utils.UpgradeProtocol {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 400, Message: fmt.Sprintf("exec requires an upgrade to %s", utils.UpgradeProtocol)})
+		return
+	}
+
+	opts := task.ExecOptions{}
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil || len(opts.Cmd) == 0 {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 400, Message: "exec requires a command"})
+		return
+	}
+
+	session, err := a.Worker.ExecTask(context.Background(), *t, opts)
+	if err != nil {
+		log.Printf("Error starting exec in task %v: %v\n", t.ID, err)
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 500, Message: err.Error()})
+		return
+	}
+	defer session.Stream.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(500)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Error hijacking connection for exec %s: %v\n", session.ID, err)
+		return
+	}
+	defer conn.Close()
+
+	headers := http.Header{}
+	headers.Set(utils.ExecIDHeader, session.ID)
+	headers.Set(utils.ExecTtyHeader, strconv.FormatBool(session.Tty))
+	err = utils.WriteSwitchingProtocols(conn, headers)
+	if err != nil {
+		return
+	}
+
+	log.Printf("Started exec %s in task %v: %v\n", session.ID, t.ID, opts.Cmd)
+	utils.Splice(&utils.UpgradedConn{Conn: conn, Reader: brw.Reader}, session.Stream)
+}
+
+func (a *Api) InspectExecHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.taskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	resp := a.Worker.InspectExec(*t, chi.URLParam(r, "execId"))
+	if resp.Error != nil {
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 404, Message: resp.Error.Error()})
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(resp.Container)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (a *Api) ResizeExecHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.taskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	height, herr := strconv.ParseUint(r.URL.Query().Get("h"), 10, 32)
+	width, werr := strconv.ParseUint(r.URL.Query().Get("w"), 10, 32)
+	if herr != nil || werr != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 400, Message: "resize requires numeric h and w"})
+		return
+	}
+
+	execID := chi.URLParam(r, "execId")
+	if resp := a.Worker.InspectExec(*t, execID); resp.Error != nil {
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 404, Message: resp.Error.Error()})
+		return
+	}
+
+	err := a.Worker.ResizeExec(*t, execID, uint(height), uint(width))
+	if err != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 500, Message: err.Error()})
+		return
+	}
+	w.WriteHeader(204)
+}
 
-}
\ No newline at end of file
+// taskFromRequest looks up the task named by the taskId URL parameter,
+// writing a 404 response if there is none.
+func (a *Api) taskFromRequest(w http.ResponseWriter, r *http.Request) (*task.Task, bool) {
+	tID, _ := uuid.Parse(chi.URLParam(r, "taskId"))
+	t, err := a.Worker.Db.Get(tID.String())
+	if err != nil {
+		log.Printf("No task with ID %v found", tID)
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(ErrResponse{HTTPStatusCode: 404, Message: err.Error()})
+		return nil, false
+	}
+	return t.(*task.Task), true
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+
+	"cube/task"
+
+	"github.com/google/uuid"
+)
+
+// PortAllocator keeps track of the host ports bound by tasks on this worker
+// so that two tasks never ask for the same port.
+type PortAllocator struct {
+	mu        sync.Mutex
+	Allocated map[uuid.UUID][]task.HostPort
+}
+
+func NewPortAllocator() *PortAllocator {
+	return &PortAllocator{
+		Allocated: make(map[uuid.UUID][]task.HostPort),
+	}
+}
+
+// Allocate reserves ports for the task, failing if any of them is already
+// held by another task.
+func (p *PortAllocator) Allocate(id uuid.UUID, ports []task.HostPort) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for owner, allocated := range p.Allocated {
+		if owner == id {
+			continue
+		}
+		if conflict := task.PortConflict(ports, allocated); conflict != nil {
+			return fmt.Errorf("host port %s is already allocated to task %s", conflict, owner)
+		}
+	}
+	if len(ports) > 0 {
+		p.Allocated[id] = ports
+	}
+	return nil
+}
+
+func (p *PortAllocator) Release(id uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.Allocated, id)
+}
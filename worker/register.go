@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cube/node"
+	"cube/stats"
+)
+
+const heartbeatInterval = 10 * time.Second
+
+// SendHeartbeats registers the worker with the manager and then keeps sending
+// it heartbeats. The worker registers again whenever the manager doesn't know
+// it, for example after the manager restarted or declared the worker lost.
+func (w *Worker) SendHeartbeats(manager string, address string, labels map[string]string, taints []node.Taint) {
+	registered := false
+	for {
+		if !registered {
+			err := w.register(manager, address, labels, taints)
+			if err != nil {
+				log.Printf("Error registering with manager %s: %v\n", manager, err)
+			} else {
+				log.Printf("Registered with manager %s as %s\n", manager, address)
+				registered = true
+			}
+		} else {
+			status, err := w.heartbeat(manager, address)
+			if err != nil {
+				log.Printf("Error sending heartbeat to manager %s: %v\n", manager, err)
+			} else if status == http.StatusNotFound {
+				registered = false
+				continue
+			}
+		}
+		time.Sleep(heartbeatInterval)
+	}
+}
+
+func (w *Worker) register(manager string, address string, labels map[string]string, taints []node.Taint) error {
+	s := w.Stats
+	if s == nil {
+		s = stats.GetStats()
+	}
+	r := node.Registration{
+		Name:    w.Name,
+		Address: address,
+		Cpu:     float64(s.CpuCount),
+		Labels:  labels,
+		Taints:  taints,
+	}
+	if s.MemStats != nil {
+		r.Memory = int64(s.MemTotalKb())
+	}
+	if s.DiskStats != nil {
+		r.Disk = int64(s.DiskTotal())
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/nodes", manager)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := ErrResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("registration failed (%d): %s", resp.StatusCode, e.Message)
+	}
+	return nil
+}
+
+func (w *Worker) heartbeat(manager string, address string) (int, error) {
+	url := fmt.Sprintf("http://%s/nodes/%s/heartbeat", manager, address)
+	req, err := http.NewRequest("PUT", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -1,7 +1,9 @@
 package worker
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"time"
 
@@ -18,6 +20,9 @@ type Worker struct {
     Db    		store.Store
     TaskCount int
 		Stats	  *stats.Stats
+		Runtime   task.Runtime
+		Runtimes  map[string]task.Runtime
+		Ports     *PortAllocator
 }
 
 func (w *Worker ) CollectStats() {
@@ -29,10 +34,11 @@ func (w *Worker ) CollectStats() {
 	}
 }
 
-func New(name string, taskDbType string) *Worker {
+func New(name string, taskDbType string, runtimeType string) *Worker {
 	w := Worker{
 			Name:  name,
 			Queue: *queue.New(),
+			Ports: NewPortAllocator(),
 	}
 
 	var s store.Store
@@ -49,10 +55,47 @@ func New(name string, taskDbType string) *Worker {
 		log.Fatalf("unable to create task store: %v", err)
 	}
 	w.Db = s
+
+	w.Runtimes = make(map[string]task.Runtime)
+	for _, name := range []string{runtimeType, "docker", "exec"} {
+		if _, ok := w.Runtimes[name]; ok {
+			continue
+		}
+		r, err := task.NewRuntime(name)
+		if err != nil {
+			if name == runtimeType {
+				log.Fatalf("unable to create runtime: %v", err)
+			}
+			log.Printf("runtime %s is unavailable: %v\n", name, err)
+			continue
+		}
+		w.Runtimes[name] = r
+	}
+	w.Runtime = w.Runtimes[runtimeType]
+
+	for _, t := range w.GetTasks() {
+		if t.State == task.Running {
+			ports, _ := task.HostPorts(t.PortBindings)
+			w.Ports.Allocate(t.ID, ports)
+		}
+	}
 	return &w
 }
 
 
+// runtimeFor returns the runtime requested by the task, or the worker's
+// default runtime when the task doesn't name one.
+func (w *Worker) runtimeFor(t task.Task) (task.Runtime, error) {
+	if t.Runtime == "" {
+		return w.Runtime, nil
+	}
+	r, ok := w.Runtimes[t.Runtime]
+	if !ok {
+		return nil, fmt.Errorf("runtime %s is not available on worker %s", t.Runtime, w.Name)
+	}
+	return r, nil
+}
+
 func (w *Worker) AddTask(t task.Task){
 	w.Queue.Enqueue(t)
 }
@@ -67,27 +110,25 @@ func (w *Worker) GetTasks() []*task.Task{
 	return taskList.([]*task.Task)
 }
 
-func (w *Worker) runTask() task.DockerResult{
+func (w *Worker) runTask() task.RuntimeResult{
 	t := w.Queue.Dequeue()
 
 	if t == nil {
 		log.Println("No tasks in the queue")
-		return task.DockerResult{Error: nil}
+		return task.RuntimeResult{Error: nil}
 	}
 
 	taskQueued := t.(task.Task)
+	var taskPersisted *task.Task
 	taskResult,err := w.Db.Get(taskQueued.ID.String());
 	if err != nil {
-		log.Printf("Task with id %s not found\n",taskQueued.ID)
-		return task.DockerResult{Error: nil}
-	}
-	taskPersisted := taskResult.(*task.Task)
-	if taskPersisted == nil {
 		taskPersisted = &taskQueued
 		w.Db.Put(taskQueued.ID.String(),&taskQueued)
+	} else {
+		taskPersisted = taskResult.(*task.Task)
 	}
 
-	var result task.DockerResult
+	var result task.RuntimeResult
 
 	if task.ValidStateTransition(taskPersisted.State,taskQueued.State) {
 		switch taskQueued.State {
@@ -96,12 +137,27 @@ func (w *Worker) runTask() task.DockerResult{
 		case task.Completed:
 			result = w.StopTask(taskQueued)
 		}
+	} else if taskQueued.State == task.Scheduled {
+		result = w.restartTask(*taskPersisted, taskQueued)
+	} else if taskQueued.State == task.Completed && finished(taskPersisted.State) {
+		result = w.removeTask(*taskPersisted)
 	} else {
 		result.Error = fmt.Errorf("Invalid transition from %v to %v", taskPersisted.State, taskQueued.State)
 	}
 	return result
 }
 
+// restartTask replaces the worker's copy of a task with a new one. The
+// manager schedules a task the worker already has to restart it, when it
+// fails its health check or comes back to the worker it was moved off.
+func (w *Worker) restartTask(old task.Task, t task.Task) task.RuntimeResult{
+	log.Printf("Restarting task %v in state %v\n", t.ID, old.State)
+	if old.State == task.Running {
+		w.StopTask(old)
+	}
+	return w.StartTask(t)
+}
+
 func (w *Worker) RunTasks() {
 	for {
 		if w.Queue.Len() != 0 {
@@ -116,14 +172,23 @@ func (w *Worker) RunTasks() {
 }
 
 
-func (w *Worker) StartTask(t task.Task) task.DockerResult{
+func (w *Worker) StartTask(t task.Task) task.RuntimeResult{
 	t.StartTime = time.Now().UTC()
 	config := task.NewConfig(&t)
-	docker := task.NewDocker(config)
 
-	result := docker.Run()
+	var result task.RuntimeResult
+	r, err := w.runtimeFor(t)
+	if err == nil {
+		err = w.allocatePorts(t)
+	}
+	if err != nil {
+		result.Error = err
+	} else {
+		result = r.Run(config)
+	}
 	if result.Error != nil {
 		log.Printf("Error running task %v: %v\n", t.ID, result.Error)
+		w.Ports.Release(t.ID)
 		t.State = task.Failed
 		w.Db.Put(t.ID.String(),&t)
 		return result
@@ -136,29 +201,97 @@ func (w *Worker) StartTask(t task.Task) task.DockerResult{
 	return result
 }
 
-func (w *Worker) StopTask(t task.Task) task.DockerResult{
-	config := task.NewConfig(&t)
-	docker := task.NewDocker(config)
-
-	result := docker.Stop(t.ContainerID,true)
+func (w *Worker) StopTask(t task.Task) task.RuntimeResult{
+	var result task.RuntimeResult
+	r, err := w.runtimeFor(t)
+	if err != nil {
+		result.Error = err
+	} else {
+		result = r.Stop(t.ContainerID,true)
+	}
 
 	if result.Error != nil {
 		log.Printf("Error stopping container %v: %v\n", t.ContainerID ,result.Error)
 	}
 
+	w.Ports.Release(t.ID)
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
 	w.Db.Put(t.ID.String(),&t)
 
-	log.Printf("Stopped and removed container $v for task %v\n", t.ContainerID,t.ID)
+	log.Printf("Stopped and removed container %v for task %v\n", t.ContainerID,t.ID)
 	return result
 }
 
 
-func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
-	config := task.NewConfig(&t)
-	d := task.NewDocker(config)
-	return d.Inspect(t.ContainerID)
+func (w *Worker) allocatePorts(t task.Task) error {
+	ports, err := task.HostPorts(t.PortBindings)
+	if err != nil {
+		return err
+	}
+	return w.Ports.Allocate(t.ID, ports)
+}
+
+func (w *Worker) InspectTask(t task.Task) task.InspectResponse {
+	r, err := w.runtimeFor(t)
+	if err != nil {
+		return task.InspectResponse{Error: err}
+	}
+	return r.Inspect(t.ContainerID)
+}
+
+func (w *Worker) TaskLogs(ctx context.Context, t task.Task, opts task.LogsOptions, stdout io.Writer, stderr io.Writer) error {
+	r, err := w.runtimeFor(t)
+	if err != nil {
+		return err
+	}
+	return r.Logs(ctx, t.ContainerID, opts, stdout, stderr)
+}
+
+func (w *Worker) execer(t task.Task) (task.Execer, error) {
+	r, err := w.runtimeFor(t)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := r.(task.Execer)
+	if !ok {
+		return nil, fmt.Errorf("the runtime of task %s does not support exec", t.ID)
+	}
+	return e, nil
+}
+
+func (w *Worker) ExecTask(ctx context.Context, t task.Task, opts task.ExecOptions) (*task.ExecSession, error) {
+	if t.State != task.Running {
+		return nil, fmt.Errorf("task %s is not running", t.ID)
+	}
+	e, err := w.execer(t)
+	if err != nil {
+		return nil, err
+	}
+	return e.Exec(ctx, t.ContainerID, opts)
+}
+
+func (w *Worker) ResizeExec(t task.Task, execID string, height uint, width uint) error {
+	e, err := w.execer(t)
+	if err != nil {
+		return err
+	}
+	return e.ExecResize(execID, height, width)
+}
+
+// InspectExec inspects an exec session of the task. A session running in
+// another workload is reported as missing, so that a task's exec URLs only
+// reach its own sessions.
+func (w *Worker) InspectExec(t task.Task, execID string) task.ExecInspectResponse {
+	e, err := w.execer(t)
+	if err != nil {
+		return task.ExecInspectResponse{Error: err}
+	}
+	resp := e.ExecInspect(execID)
+	if resp.Error == nil && resp.ContainerID != t.ContainerID {
+		return task.ExecInspectResponse{Error: fmt.Errorf("no exec %s for task %s", execID, t.ID)}
+	}
+	return resp
 }
 
 func (w *Worker) UpdateTasks() {
@@ -169,27 +302,84 @@ func (w *Worker) UpdateTasks() {
 }
 
 func (w *Worker) updateTasks() {
-	for id, t := range w.GetTasks() {
+	for _, t := range w.GetTasks() {
 		if t.State == task.Running {
 				resp := w.InspectTask(*t)
 				if resp.Error != nil {
-					fmt.Printf("ERROR: %v\n", resp.Error)
-				}
-
-				if resp.Container == nil {
-					log.Printf("No container for running task %s\n", id)
+					log.Printf("No container for running task %s: %v\n", t.ID, resp.Error)
+					w.Ports.Release(t.ID)
 					t.State = task.Failed
 					w.Db.Put(t.ID.String(), t)
+					continue
 				}
 
-				if resp.Container.State.Status == "exited" {
-					log.Printf("Container for task %s in non-running state %s",id, resp.Container.State.Status)
-					t.State = task.Failed
+				if resp.Status == "exited" || resp.Status == "dead" {
+					log.Printf("Container for task %s in non-running state %s", t.ID, resp.Status)
+					w.Ports.Release(t.ID)
+					t.State = exitedState(*t, resp)
+					t.ExitCode = resp.ExitCode
+					t.OOMKilled = resp.OOMKilled
+					t.Error = exitError(resp)
+					t.FinishTime = time.Now().UTC()
 					w.Db.Put(t.ID.String(), t)
+					continue
 				}
-				t.HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
+				t.HostPorts = resp.Ports
 				w.Db.Put(t.ID.String(), t)
 		}
+	}
 }
+
+// removeTask forgets a finished task. The workload of a task that exited on
+// its own is kept for its logs until then, the manager stops a finished task
+// again when it no longer needs it.
+func (w *Worker) removeTask(t task.Task) task.RuntimeResult{
+	var result task.RuntimeResult
+	r, err := w.runtimeFor(t)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	// a task stopped by the manager had its workload removed with it
+	if r.Inspect(t.ContainerID).Error == nil {
+		result = r.Stop(t.ContainerID, true)
+		if result.Error != nil {
+			log.Printf("Error removing workload %s of task %s: %v\n", t.ContainerID, t.ID, result.Error)
+			return result
+		}
+	}
+
+	w.Db.Delete(t.ID.String())
+	log.Printf("Removed finished task %v\n", t.ID)
+	return result
+}
+
+func finished(s task.State) bool {
+	return s == task.Completed || s == task.Failed
 }
 
+// exitedState maps a workload that is no longer running onto the task state
+// machine. A job that exits cleanly is completed, and so is an exec task
+// without a type, whose host process runs to completion. Any other exit, and
+// every exit of a service, is a failure.
+func exitedState(t task.Task, resp task.InspectResponse) task.State {
+	if resp.ExitCode != 0 || resp.OOMKilled {
+		return task.Failed
+	}
+	if t.IsJob() || (t.Type == "" && t.Runtime == "exec") {
+		return task.Completed
+	}
+	return task.Failed
+}
+
+func exitError(resp task.InspectResponse) string {
+	switch {
+	case resp.StateError != "":
+		return resp.StateError
+	case resp.OOMKilled:
+		return "killed after running out of memory"
+	case resp.ExitCode != 0:
+		return fmt.Sprintf("exited with code %d", resp.ExitCode)
+	}
+	return ""
+}
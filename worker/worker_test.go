@@ -0,0 +1,271 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"cube/task"
+)
+
+func newTestWorker(t *testing.T) (*Worker, *task.InMemoryRuntime) {
+	w := New("test", "memory", "memory")
+	rt, ok := w.Runtime.(*task.InMemoryRuntime)
+	if !ok {
+		t.Fatalf("runtime = %T, want *task.InMemoryRuntime", w.Runtime)
+	}
+	return w, rt
+}
+
+func newTestTask(taskType string) task.Task {
+	return task.Task{
+		ID:    uuid.New(),
+		Name:  "test",
+		State: task.Scheduled,
+		Image: "test",
+		Type:  taskType,
+	}
+}
+
+func storedTask(t *testing.T, w *Worker, id uuid.UUID) *task.Task {
+	result, err := w.Db.Get(id.String())
+	if err != nil {
+		t.Fatalf("task %s is not stored: %v", id, err)
+	}
+	return result.(*task.Task)
+}
+
+// startTestTask runs a scheduled task through the worker queue and checks
+// that it is running.
+func startTestTask(t *testing.T, w *Worker, rt *task.InMemoryRuntime, tk task.Task) *task.Task {
+	w.AddTask(tk)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("starting task: %v", result.Error)
+	}
+	stored := storedTask(t, w, tk.ID)
+	if stored.State != task.Running {
+		t.Fatalf("state = %v, want Running", stored.State)
+	}
+	if rt.Inspect(stored.ContainerID).Status != "running" {
+		t.Fatalf("workload %s is not running", stored.ContainerID)
+	}
+	return stored
+}
+
+func TestRunTaskStartsAndStops(t *testing.T) {
+	w, rt := newTestWorker(t)
+	stored := startTestTask(t, w, rt, newTestTask(task.TypeService))
+
+	stop := *stored
+	stop.State = task.Completed
+	w.AddTask(stop)
+	if result := w.runTask(); result.Error != nil {
+		t.Fatalf("stopping task: %v", result.Error)
+	}
+	if state := storedTask(t, w, stop.ID).State; state != task.Completed {
+		t.Errorf("state = %v, want Completed", state)
+	}
+	if _, ok := rt.Db[stored.ContainerID]; ok {
+		t.Errorf("workload %s was not removed", stored.ContainerID)
+	}
+}
+
+func TestRunTaskRejectsInvalidTransition(t *testing.T) {
+	w, rt := newTestWorker(t)
+	stored := startTestTask(t, w, rt, newTestTask(task.TypeService))
+
+	pending := *stored
+	pending.State = task.Pending
+	w.AddTask(pending)
+	if result := w.runTask(); result.Error == nil {
+		t.Errorf("moving a running task back to Pending succeeded")
+	}
+	if state := storedTask(t, w, stored.ID).State; state != task.Running {
+		t.Errorf("state = %v, want Running", state)
+	}
+}
+
+func TestRunTaskRestartsScheduledAgain(t *testing.T) {
+	w, rt := newTestWorker(t)
+	tk := newTestTask(task.TypeService)
+	first := startTestTask(t, w, rt, tk)
+
+	second := startTestTask(t, w, rt, tk)
+	if second.ContainerID == first.ContainerID {
+		t.Fatalf("task was not restarted in a new workload")
+	}
+	if _, ok := rt.Db[first.ContainerID]; ok {
+		t.Errorf("old workload %s was not removed", first.ContainerID)
+	}
+}
+
+func TestUpdateTasksRecordsExit(t *testing.T) {
+	tests := []struct {
+		name     string
+		taskType string
+		code     int
+		want     task.State
+	}{
+		{"job succeeds", task.TypeJob, 0, task.Completed},
+		{"job fails", task.TypeJob, 1, task.Failed},
+		{"service exits", task.TypeService, 0, task.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			stored := startTestTask(t, w, rt, newTestTask(tt.taskType))
+
+			if err := rt.Exit(stored.ContainerID, tt.code); err != nil {
+				t.Fatal(err)
+			}
+			w.updateTasks()
+
+			got := storedTask(t, w, stored.ID)
+			if got.State != tt.want {
+				t.Errorf("state = %v, want %v", got.State, tt.want)
+			}
+			if got.ExitCode != tt.code {
+				t.Errorf("exit code = %d, want %d", got.ExitCode, tt.code)
+			}
+			if _, ok := rt.Db[stored.ContainerID]; !ok {
+				t.Errorf("workload %s was removed", stored.ContainerID)
+			}
+		})
+	}
+}
+
+func TestTaskLogsOfCompletedJob(t *testing.T) {
+	w, rt := newTestWorker(t)
+	stored := startTestTask(t, w, rt, newTestTask(task.TypeJob))
+
+	rt.Db[stored.ContainerID].Stdout = []byte("done\n")
+	if err := rt.Exit(stored.ContainerID, 0); err != nil {
+		t.Fatal(err)
+	}
+	w.updateTasks()
+
+	var out bytes.Buffer
+	err := w.TaskLogs(context.Background(), *storedTask(t, w, stored.ID), task.LogsOptions{Stdout: true}, &out, &out)
+	if err != nil {
+		t.Fatalf("logs of completed job: %v", err)
+	}
+	if out.String() != "done\n" {
+		t.Errorf("logs = %q, want %q", out.String(), "done\n")
+	}
+}
+
+func TestRunTaskRemovesFinishedTask(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish ends the task, by its exit or by a stop from the manager
+		finish func(w *Worker, rt *task.InMemoryRuntime, stored *task.Task)
+	}{
+		{"job exited", func(w *Worker, rt *task.InMemoryRuntime, stored *task.Task) {
+			rt.Exit(stored.ContainerID, 0)
+			w.updateTasks()
+		}},
+		{"task stopped", func(w *Worker, rt *task.InMemoryRuntime, stored *task.Task) {
+			w.StopTask(*stored)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, rt := newTestWorker(t)
+			stored := startTestTask(t, w, rt, newTestTask(task.TypeJob))
+			tt.finish(w, rt, stored)
+
+			stop := *storedTask(t, w, stored.ID)
+			stop.State = task.Completed
+			w.AddTask(stop)
+			if result := w.runTask(); result.Error != nil {
+				t.Fatalf("removing task: %v", result.Error)
+			}
+			if _, ok := rt.Db[stored.ContainerID]; ok {
+				t.Errorf("workload %s was not removed", stored.ContainerID)
+			}
+			if _, err := w.Db.Get(stored.ID.String()); err == nil {
+				t.Errorf("task %s is still stored", stored.ID)
+			}
+		})
+	}
+}
+
+func TestExitedState(t *testing.T) {
+	tests := []struct {
+		taskType string
+		runtime  string
+		code     int
+		want     task.State
+	}{
+		{"", "", 0, task.Failed},
+		{"", "exec", 0, task.Completed},
+		{"", "exec", 1, task.Failed},
+		{task.TypeService, "exec", 0, task.Failed},
+		{task.TypeJob, "", 0, task.Completed},
+		{task.TypeJob, "exec", 2, task.Failed},
+	}
+	for _, tt := range tests {
+		tk := task.Task{Type: tt.taskType, Runtime: tt.runtime}
+		got := exitedState(tk, task.InspectResponse{Status: "exited", ExitCode: tt.code})
+		if got != tt.want {
+			t.Errorf("type %q runtime %q exit %d: state = %v, want %v", tt.taskType, tt.runtime, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateTasksFailsMissingWorkload(t *testing.T) {
+	w, rt := newTestWorker(t)
+	stored := startTestTask(t, w, rt, newTestTask(task.TypeService))
+
+	delete(rt.Db, stored.ContainerID)
+	w.updateTasks()
+	if state := storedTask(t, w, stored.ID).State; state != task.Failed {
+		t.Errorf("state = %v, want Failed", state)
+	}
+}
+
+// execRuntime adds exec sessions to the in-memory runtime, each running in
+// the workload it was started in.
+type execRuntime struct {
+	*task.InMemoryRuntime
+	sessions map[string]string
+}
+
+func (e *execRuntime) Exec(ctx context.Context, id string, opts task.ExecOptions) (*task.ExecSession, error) {
+	execID := uuid.New().String()
+	e.sessions[execID] = id
+	return &task.ExecSession{ID: execID}, nil
+}
+
+func (e *execRuntime) ExecResize(execID string, height uint, width uint) error {
+	return nil
+}
+
+func (e *execRuntime) ExecInspect(execID string) task.ExecInspectResponse {
+	id, ok := e.sessions[execID]
+	if !ok {
+		return task.ExecInspectResponse{Error: fmt.Errorf("no exec %s", execID)}
+	}
+	return task.ExecInspectResponse{ContainerID: id, Running: true}
+}
+
+func TestInspectExecOfAnotherTask(t *testing.T) {
+	w, rt := newTestWorker(t)
+	w.Runtime = &execRuntime{InMemoryRuntime: rt, sessions: make(map[string]string)}
+	mine := startTestTask(t, w, rt, newTestTask(task.TypeService))
+	other := startTestTask(t, w, rt, newTestTask(task.TypeService))
+
+	session, err := w.ExecTask(context.Background(), *other, task.ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := w.InspectExec(*other, session.ID); resp.Error != nil {
+		t.Errorf("inspecting the task's own exec: %v", resp.Error)
+	}
+	if resp := w.InspectExec(*mine, session.ID); resp.Error == nil {
+		t.Errorf("the exec of another task was reachable through task %s", mine.ID)
+	}
+}